@@ -1,16 +1,38 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/iwaco/movies/internal/config"
 	"github.com/iwaco/movies/internal/converter"
+	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/importer"
 )
 
 func main() {
+	cfg := config.Load()
+
 	inputFile := flag.String("input", "", "input JS file path (required)")
-	output := flag.String("output", "", "output file path (default: stdout)")
+	output := flag.String("output", "", "output file path (default: stdout, or none with -watch)")
+	varName := flag.String("var", "", "variable to convert when the input declares several (default: movies, or the first one)")
+	mediaRoot := flag.String("media-root", "", "check that the resolved jpg, pictures and format paths exist under this directory")
+	onMissing := flag.String("on-missing", "fail", "with -media-root, what to do with missing paths: fail (exit 1), drop (remove them from the output) or warn")
+	watch := flag.Bool("watch", false, "poll the input and import it into the database whenever it changes")
+	interval := flag.Duration("interval", 2*time.Second, "with -watch, how often to poll the input")
+	debounce := flag.Duration("debounce", time.Second, "with -watch, how long the input must stay unchanged before it is imported")
+	dbPath := flag.String("db", cfg.DBPath, "with -watch, database file path")
+	source := flag.String("source", "", "with -watch, source label of the imported videos")
+	sync := flag.Bool("sync", false, "with -watch, remove the videos missing from the input")
 	flag.Parse()
 
 	if *inputFile == "" {
@@ -18,6 +40,33 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	switch *onMissing {
+	case "fail", "drop", "warn":
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid -on-missing %q\n", *onMissing)
+		os.Exit(1)
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "error: -interval must be positive, got %s\n", *interval)
+		os.Exit(1)
+	}
+	if *debounce < 0 {
+		fmt.Fprintf(os.Stderr, "error: -debounce must not be negative, got %s\n", *debounce)
+		os.Exit(1)
+	}
+
+	c := &conversion{varName: *varName, mediaRoot: *mediaRoot, onMissing: *onMissing}
+	if *watch {
+		opts := importer.Options{Format: importer.FormatJSON, BatchSize: cfg.ImportBatchSize, Source: *source}
+		if *sync {
+			opts.Sync = &importer.SyncOptions{MaxRemovePercent: cfg.SyncMaxRemovePercent}
+		}
+		if err := watchInput(*inputFile, *output, *dbPath, *interval, *debounce, c, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	input, err := os.ReadFile(*inputFile)
 	if err != nil {
@@ -25,7 +74,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	result, err := converter.ConvertFile(input)
+	result, err := c.convert(input)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error converting: %v\n", err)
 		os.Exit(1)
@@ -40,3 +89,87 @@ func main() {
 		fmt.Println(string(result))
 	}
 }
+
+// conversion converts movies.js into import JSON, checking the media
+// paths when mediaRoot is set.
+type conversion struct {
+	varName   string
+	mediaRoot string
+	onMissing string
+}
+
+func (c *conversion) convert(input []byte) ([]byte, error) {
+	movies, err := converter.ConvertJS(input, c.varName)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.mediaRoot != "" {
+		missing := converter.CheckMedia(movies, c.mediaRoot)
+		for _, m := range missing {
+			fmt.Fprintf(os.Stderr, "missing: %s\n", m)
+		}
+		if len(missing) > 0 {
+			switch c.onMissing {
+			case "fail":
+				return nil, fmt.Errorf("%d paths missing under %s", len(missing), c.mediaRoot)
+			case "drop":
+				converter.DropMissing(movies, missing)
+			}
+		}
+	}
+
+	result, err := json.MarshalIndent(movies, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return result, nil
+}
+
+// watchInput imports the input into the database on every change until
+// interrupted. A failure is logged and retried when the input changes
+// again, except for an import that found the database busy, which is
+// retried at the next poll.
+func watchInput(input, output, dbPath string, interval, debounce time.Duration, c *conversion, opts importer.Options) error {
+	db, err := database.New(dbPath)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer db.Close()
+	imp := importer.New(db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("watching %s", input)
+	err = converter.Watch(ctx, input, converter.WatchOptions{Interval: interval, Debounce: debounce}, func(data []byte) error {
+		result, err := c.convert(data)
+		if err != nil {
+			log.Printf("error converting %s: %v", input, err)
+			return err
+		}
+		if output != "" {
+			if err := os.WriteFile(output, result, 0644); err != nil {
+				log.Printf("error writing output file: %v", err)
+			}
+		}
+		res, err := imp.ImportStreamContext(ctx, bytes.NewReader(result), opts)
+		if database.IsBusy(err) {
+			log.Printf("error importing %s, retrying: %v", input, err)
+			return converter.Retry(err)
+		}
+		if err != nil {
+			// Such as a sync refused by its threshold, which the same
+			// content would be again
+			log.Printf("error importing %s: %v", input, err)
+			return err
+		}
+		log.Printf("imported %s: %d created, %d updated, %d unchanged, %d failed, %d removed",
+			input, res.Created, res.Updated, res.Unchanged, res.Failed, res.Removed)
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iwaco/movies/internal/config"
+	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/importer"
+)
+
+func main() {
+	cfg := config.Load()
+
+	inputFile := flag.String("input", "", "input JSON, NDJSON, movies.js, CSV or TSV file path (default: stdin)")
+	dbPath := flag.String("db", cfg.DBPath, "database file path")
+	format := flag.String("format", importer.FormatAuto, "input format: json, ndjson, js, csv or tsv (default: detect)")
+	batchSize := flag.Int("batch-size", cfg.ImportBatchSize, "records committed per transaction")
+	strict := flag.Bool("strict", false, "roll back the whole import if any record fails")
+	dryRun := flag.Bool("dry-run", false, "report the changes without writing them")
+	source := flag.String("source", "", "source label of the imported videos")
+	sync := flag.Bool("sync", false, "remove the videos missing from the input")
+	prefix := flag.String("prefix", "", "with -sync, only remove videos whose ID starts with this prefix")
+	hardDelete := flag.Bool("hard-delete", false, "with -sync, delete missing videos instead of marking them deleted")
+	maxRemove := flag.Int("max-remove", cfg.SyncMaxRemovePercent, "with -sync, refuse to remove more than this percentage of the videos in scope")
+	force := flag.Bool("force", false, "with -sync, remove missing videos beyond -max-remove")
+	flag.Parse()
+
+	var input io.Reader = os.Stdin
+	if *inputFile != "" {
+		f, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading input file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	opts := importer.Options{
+		Format:    *format,
+		BatchSize: *batchSize,
+		Strict:    *strict,
+		DryRun:    *dryRun,
+		Source:    *source,
+	}
+	if *sync {
+		opts.Sync = &importer.SyncOptions{Prefix: *prefix, Hard: *hardDelete, MaxRemovePercent: *maxRemove, Force: *force}
+	}
+	result, err := importer.New(db).ImportStream(input, opts)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error importing: %v\n", err)
+		os.Exit(1)
+	}
+	if *strict && result.Failed > 0 {
+		os.Exit(1)
+	}
+}
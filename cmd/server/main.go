@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/iwaco/movies/internal/config"
 	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/importer"
 	"github.com/iwaco/movies/internal/router"
 )
 
+// shutdownTimeout is how long requests and import jobs get to finish on
+// shutdown before they are cancelled.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	cfg := config.Load()
 
@@ -19,13 +29,37 @@ func main() {
 	}
 	defer db.Close()
 
-	r := router.New(db, cfg)
+	jobs := importer.NewJobs(importer.New(db), "")
+	r := router.New(db, cfg, jobs)
 
 	handler := router.WithSPAFallback(r, "frontend/dist/index.html")
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	addr := fmt.Sprintf(":%s", cfg.Port)
-	log.Printf("starting server on %s", addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("starting server on %s", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
 		log.Fatalf("server error: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+	// Jobs still running at the timeout are cancelled and record their state
+	if err := jobs.Shutdown(shutdownCtx); err != nil {
+		log.Printf("import jobs cancelled: %v", err)
 	}
 }
@@ -9,6 +9,7 @@ export interface Video {
   tags: Tag[];
   formats: VideoFormat[];
   rating: number;
+  source?: string;
   created_at: string;
   updated_at: string;
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -10,14 +11,22 @@ type Config struct {
 	DBPath    string
 	MediaRoot string
 	Port      string
+	// ImportBatchSize is the default number of records committed per
+	// transaction by streaming imports.
+	ImportBatchSize int
+	// SyncMaxRemovePercent is the default share of the videos in scope a
+	// sync import may remove without being forced.
+	SyncMaxRemovePercent int
 }
 
 func Load() *Config {
 	_ = godotenv.Load()
 	return &Config{
-		DBPath:    getEnv("MOVIES_DB_PATH", "movies.db"),
-		MediaRoot: getEnv("MOVIES_MEDIA_ROOT", "./media"),
-		Port:      getEnv("MOVIES_PORT", "8080"),
+		DBPath:               getEnv("MOVIES_DB_PATH", "movies.db"),
+		MediaRoot:            getEnv("MOVIES_MEDIA_ROOT", "./media"),
+		Port:                 getEnv("MOVIES_PORT", "8080"),
+		ImportBatchSize:      getEnvInt("MOVIES_IMPORT_BATCH_SIZE", 500),
+		SyncMaxRemovePercent: getEnvPercent("MOVIES_SYNC_MAX_REMOVE_PERCENT", 10),
 	}
 }
 
@@ -27,3 +36,18 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// getEnvPercent is getEnvInt for a percentage, accepting 0 as well.
+func getEnvPercent(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 && v <= 100 {
+		return v
+	}
+	return fallback
+}
@@ -111,3 +111,43 @@ func TestLoadWithoutDotEnvFile(t *testing.T) {
 		t.Errorf("expected default Port '8080', got %q", cfg.Port)
 	}
 }
+
+func TestLoadImportBatchSize(t *testing.T) {
+	tests := []struct {
+		env    string
+		expect int
+	}{
+		{"", 500},
+		{"1000", 1000},
+		{"0", 500},
+		{"abc", 500},
+	}
+	for _, tt := range tests {
+		t.Setenv("MOVIES_IMPORT_BATCH_SIZE", tt.env)
+		if cfg := Load(); cfg.ImportBatchSize != tt.expect {
+			t.Errorf("MOVIES_IMPORT_BATCH_SIZE=%q: expected %d, got %d", tt.env, tt.expect, cfg.ImportBatchSize)
+		}
+	}
+}
+
+func TestLoadSyncMaxRemovePercent(t *testing.T) {
+	t.Setenv("MOVIES_SYNC_MAX_REMOVE_PERCENT", "")
+	if cfg := Load(); cfg.SyncMaxRemovePercent != 10 {
+		t.Errorf("expected default 10, got %d", cfg.SyncMaxRemovePercent)
+	}
+	t.Setenv("MOVIES_SYNC_MAX_REMOVE_PERCENT", "50")
+	if cfg := Load(); cfg.SyncMaxRemovePercent != 50 {
+		t.Errorf("expected 50, got %d", cfg.SyncMaxRemovePercent)
+	}
+	// 0 turns the threshold off
+	t.Setenv("MOVIES_SYNC_MAX_REMOVE_PERCENT", "0")
+	if cfg := Load(); cfg.SyncMaxRemovePercent != 0 {
+		t.Errorf("expected 0, got %d", cfg.SyncMaxRemovePercent)
+	}
+	for _, env := range []string{"-1", "101"} {
+		t.Setenv("MOVIES_SYNC_MAX_REMOVE_PERCENT", env)
+		if cfg := Load(); cfg.SyncMaxRemovePercent != 10 {
+			t.Errorf("MOVIES_SYNC_MAX_REMOVE_PERCENT=%q: expected the default, got %d", env, cfg.SyncMaxRemovePercent)
+		}
+	}
+}
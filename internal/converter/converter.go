@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
-	"regexp"
-	"strings"
 )
 
 // MovieOutput represents the output format compatible with the import API.
@@ -34,39 +32,6 @@ type movieJS struct {
 	Formats map[string]string `json:"formats"`
 }
 
-var unquotedKeyRe = regexp.MustCompile(`(?m)([\{\,]\s*)([a-zA-Z_][a-zA-Z0-9_]*)\s*:`)
-
-// ParseJS converts a JavaScript variable assignment (var movies = [...];)
-// into valid JSON bytes.
-func ParseJS(input []byte) ([]byte, error) {
-	s := string(input)
-
-	// Remove "var movies = " prefix (with flexible whitespace)
-	s = strings.TrimSpace(s)
-	idx := strings.Index(s, "=")
-	if idx == -1 {
-		return nil, fmt.Errorf("no assignment found in input")
-	}
-	s = strings.TrimSpace(s[idx+1:])
-
-	// Remove trailing semicolon
-	s = strings.TrimRight(s, "; \t\n\r")
-
-	// Quote unquoted keys: { key: or , key: → { "key": or , "key":
-	s = unquotedKeyRe.ReplaceAllString(s, `${1}"${2}":`)
-
-	// Remove trailing commas before } or ]
-	trailingCommaRe := regexp.MustCompile(`,\s*([}\]])`)
-	s = trailingCommaRe.ReplaceAllString(s, `$1`)
-
-	// Validate it's valid JSON
-	if !json.Valid([]byte(s)) {
-		return nil, fmt.Errorf("resulting JSON is not valid")
-	}
-
-	return []byte(s), nil
-}
-
 // Convert transforms parsed JSON data into MovieOutput structs with resolved paths.
 func Convert(jsonData []byte) ([]MovieOutput, error) {
 	var jsMovies []movieJS
@@ -108,14 +73,15 @@ func Convert(jsonData []byte) ([]MovieOutput, error) {
 
 // ConvertFile reads a JS file and returns formatted JSON output.
 func ConvertFile(input []byte) ([]byte, error) {
-	jsonData, err := ParseJS(input)
-	if err != nil {
-		return nil, fmt.Errorf("parse JS: %w", err)
-	}
+	return ConvertFileVar(input, "")
+}
 
-	movies, err := Convert(jsonData)
+// ConvertFileVar is ConvertFile reading the variable name, as ParseJSVar
+// does.
+func ConvertFileVar(input []byte, name string) ([]byte, error) {
+	movies, err := ConvertJS(input, name)
 	if err != nil {
-		return nil, fmt.Errorf("convert: %w", err)
+		return nil, err
 	}
 
 	out, err := json.MarshalIndent(movies, "", "  ")
@@ -125,3 +91,18 @@ func ConvertFile(input []byte) ([]byte, error) {
 
 	return out, nil
 }
+
+// ConvertJS parses the variable name of a JS file, as ParseJSVar does, and
+// converts its movies.
+func ConvertJS(input []byte, name string) ([]MovieOutput, error) {
+	jsonData, err := ParseJSVar(input, name)
+	if err != nil {
+		return nil, fmt.Errorf("parse JS: %w", err)
+	}
+
+	movies, err := Convert(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	return movies, nil
+}
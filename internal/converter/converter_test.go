@@ -1,9 +1,14 @@
 package converter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseJS_Basic(t *testing.T) {
@@ -74,6 +79,135 @@ func TestParseJS_Empty(t *testing.T) {
 	}
 }
 
+func TestParseJS_Tricky(t *testing.T) {
+	input, err := os.ReadFile("testdata/tricky.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseJS(input)
+	if err != nil {
+		t.Fatalf("ParseJS() error = %v", err)
+	}
+
+	var arr []map[string]interface{}
+	if err := json.Unmarshal(got, &arr); err != nil {
+		t.Fatalf("ParseJS() returned invalid JSON: %v", err)
+	}
+	if len(arr) != 1 {
+		t.Fatalf("ParseJS() returned %d items, want 1", len(arr))
+	}
+	m := arr[0]
+	want := map[string]interface{}{
+		"jpg":     "it's.jpg",
+		"detail":  "pics/",
+		"title":   `A { fake: "key" }, with, commas`,
+		"actors":  []interface{}{`Actor "A"`, "Actor B", "Actor C"},
+		"tags":    []interface{}{"line\nbreak", "tab\there", "emoji \U0001F600", "\U0001F3AC"},
+		"formats": map[string]interface{}{"720p": "video_720p.mp4", "1080": "video_1080p.mp4"},
+		"rating":  -15.0,
+		"views":   16.0,
+		"hidden":  nil,
+	}
+	for k, v := range want {
+		if got, _ := json.Marshal(m[k]); string(got) != mustMarshal(t, v) {
+			t.Errorf("%s = %s, want %s", k, got, mustMarshal(t, v))
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseJSVar(t *testing.T) {
+	input, err := os.ReadFile("testdata/tricky.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"other", `{"title":"not a movie list"}`},
+		{"updated", `"2024-06-01"`},
+		{"count", `2`},
+	}
+	for _, tt := range tests {
+		got, err := ParseJSVar(input, tt.name)
+		if err != nil {
+			t.Fatalf("ParseJSVar(%q) error = %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("ParseJSVar(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ParseJSVar(input, "missing"); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("ParseJSVar(missing) error = %v, want not found", err)
+	}
+
+	// Without movies the first variable is picked, and assignments count
+	got, err := ParseJS([]byte("var list = [1];\nwindow.list = [2]\n"))
+	if err != nil || string(got) != "[2]" {
+		t.Errorf("ParseJS() = %s, %v, want [2]", got, err)
+	}
+}
+
+// TestParseJS_Malformed runs every file in testdata/malformed, each of
+// which must be listed here with its error.
+func TestParseJS_Malformed(t *testing.T) {
+	tests := map[string]string{
+		"array_hole.js":            "line 1, column 17: array holes are not supported",
+		"bad_escape.js":            `line 2, column 26: invalid \x escape`,
+		"bad_number.js":            `line 2, column 21: invalid number "12abc"`,
+		"function_call.js":         `line 2, column 20: unexpected "new", expected a value`,
+		"missing_comma.js":         `line 2, column 13: unexpected "title", expected "," or "}"`,
+		"missing_key.js":           `line 2, column 16: unexpected ":", expected a property name`,
+		"missing_semicolon.js":     `line 1, column 17: unexpected "var", expected ";"`,
+		"no_declaration.js":        "no variable declaration found",
+		"statement.js":             "line 1, column 12: unexpected character '('",
+		"template_substitution.js": "line 2, column 22: template substitutions are not supported",
+		"unclosed_array.js":        `line 3, column 1: unexpected end of input, expected "," or "]"`,
+		"unterminated_comment.js":  "line 2, column 3: unterminated comment",
+		"unterminated_string.js":   "line 2, column 21: unterminated string",
+	}
+
+	files, err := filepath.Glob("testdata/malformed/*.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(tests) {
+		t.Errorf("found %d malformed inputs, want %d", len(files), len(tests))
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		want, ok := tests[name]
+		if !ok {
+			t.Errorf("%s: no expected error", name)
+			continue
+		}
+		input, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseJS(input)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: ParseJS() error = %v, want %s", name, err, want)
+		}
+		var syntaxErr *SyntaxError
+		if strings.HasPrefix(want, "line ") && !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected a *SyntaxError, got %T", name, err)
+		}
+	}
+}
+
 func TestConvert(t *testing.T) {
 	input, err := os.ReadFile("testdata/basic.js")
 	if err != nil {
@@ -254,3 +388,130 @@ func TestConvertFile_UnquotedKeys(t *testing.T) {
 		t.Errorf("PicturesDir = %q, want %q", m.PicturesDir, "/unquoted/dir/gallery/")
 	}
 }
+
+func TestCheckMedia(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"some/dir/pics", "other/path/images.jpg"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"some/dir/thumb.jpg", "some/dir/video_720p.mp4", "other/path/images/x"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	movies := []MovieOutput{
+		{ID: "abc123", JPG: "/some/dir/thumb.jpg", PicturesDir: "/some/dir/pics/",
+			Formats: map[string]string{"720p": "/some/dir/video_720p.mp4", "1080p": "/some/dir/video_1080p.mp4"}},
+		{ID: "def456", JPG: "/other/path/images.jpg", PicturesDir: "/other/path/images/x/",
+			Formats: map[string]string{"480p": "/../outside.mp4"}},
+		// Convert of a movie without a dir or jpg
+		{ID: "ghi789", JPG: "/", PicturesDir: "//"},
+	}
+
+	missing := CheckMedia(movies, root)
+	var got []string
+	for _, m := range missing {
+		got = append(got, m.String())
+	}
+	want := []string{
+		"video abc123: formats.1080p /some/dir/video_1080p.mp4: not found",
+		"video def456: jpg /other/path/images.jpg: not a file",
+		"video def456: pictures_dir /other/path/images/x/: not a directory",
+		"video def456: formats.480p /../outside.mp4: outside the media root",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CheckMedia() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	DropMissing(movies, missing)
+	if len(movies[0].Formats) != 1 || movies[0].JPG == "" || movies[0].PicturesDir == "" {
+		t.Errorf("DropMissing() changed the wrong fields of %+v", movies[0])
+	}
+	if movies[1].JPG != "" || movies[1].PicturesDir != "" || len(movies[1].Formats) != 0 {
+		t.Errorf("DropMissing() left missing paths in %+v", movies[1])
+	}
+	if len(CheckMedia(movies, root)) != 0 {
+		t.Errorf("expected nothing missing after DropMissing()")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.js")
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write("var movies = [1];", start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := make(chan string, 10)
+	// The first call fails for good and the second one for a while
+	errs := []error{errors.New("refused"), Retry(errors.New("busy"))}
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, WatchOptions{Interval: 5 * time.Millisecond, Debounce: 20 * time.Millisecond}, func(data []byte) error {
+			calls <- string(data)
+			if len(errs) > 0 {
+				err := errs[0]
+				errs = errs[1:]
+				return err
+			}
+			return nil
+		})
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case data := <-calls:
+			return data
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a change")
+			return ""
+		}
+	}
+
+	if got := next(); got != "var movies = [1];" {
+		t.Errorf("first call = %q", got)
+	}
+	// A permanent error is not retried, even when the file is touched
+	// without changing it
+	time.Sleep(50 * time.Millisecond)
+	write("var movies = [1];", start.Add(time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if len(calls) != 0 {
+		t.Errorf("unexpected retry of unchanged content: %q", <-calls)
+	}
+	write("var movies = [2];", start.Add(2*time.Minute))
+	if got := next(); got != "var movies = [2];" {
+		t.Errorf("second call = %q, want the changed content", got)
+	}
+	// A Retry error is retried at the next poll with the same content
+	if got := next(); got != "var movies = [2];" {
+		t.Errorf("retried call = %q", got)
+	}
+	write("var movies = [2];", start.Add(3*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Watch() error = %v, want context.Canceled", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("unexpected calls: %d", len(calls))
+	}
+
+	if err := Watch(context.Background(), path, WatchOptions{}, func([]byte) error { return nil }); err == nil {
+		t.Error("Watch() with a zero interval succeeded, want an error")
+	}
+}
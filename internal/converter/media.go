@@ -0,0 +1,95 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MissingFile is a path of a converted movie that does not resolve to a
+// file, or a directory for pictures_dir, under the media root.
+type MissingFile struct {
+	ID string
+	// Field is jpg, pictures_dir or formats.<name>.
+	Field  string
+	Path   string
+	Reason string
+}
+
+func (m MissingFile) String() string {
+	return fmt.Sprintf("video %s: %s %s: %s", m.ID, m.Field, m.Path, m.Reason)
+}
+
+// CheckMedia returns the paths of movies missing under root, in the order
+// of the movies and of their fields.
+func CheckMedia(movies []MovieOutput, root string) []MissingFile {
+	var missing []MissingFile
+	for _, m := range movies {
+		check := func(field, path string, dir bool) {
+			if reason := checkPath(root, path, dir); reason != "" {
+				missing = append(missing, MissingFile{ID: m.ID, Field: field, Path: path, Reason: reason})
+			}
+		}
+		check("jpg", m.JPG, false)
+		check("pictures_dir", m.PicturesDir, true)
+		for _, name := range slices.Sorted(maps.Keys(m.Formats)) {
+			check("formats."+name, m.Formats[name], false)
+		}
+	}
+	return missing
+}
+
+// checkPath returns why path is not a file, or a directory when dir is
+// set, under root, or "" when it is or path is empty. Slashes alone, which
+// Convert makes of an empty dir and jpg, count as empty.
+func checkPath(root, path string, dir bool) string {
+	if strings.Trim(path, "/") == "" {
+		return ""
+	}
+	rel := strings.TrimPrefix(path, "/")
+	if !filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(rel, "/"))) {
+		return "outside the media root"
+	}
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not found"
+	case err != nil:
+		return err.Error()
+	case dir && !info.IsDir():
+		return "not a directory"
+	case !dir && !info.Mode().IsRegular():
+		return "not a file"
+	}
+	return ""
+}
+
+// DropMissing removes the missing paths from movies: jpg and pictures_dir
+// are cleared and missing formats deleted.
+func DropMissing(movies []MovieOutput, missing []MissingFile) {
+	byID := map[string][]MissingFile{}
+	for _, m := range missing {
+		byID[m.ID] = append(byID[m.ID], m)
+	}
+	for i := range movies {
+		m := &movies[i]
+		for _, f := range byID[m.ID] {
+			switch {
+			case f.Field == "jpg" && m.JPG == f.Path:
+				m.JPG = ""
+			case f.Field == "pictures_dir" && m.PicturesDir == f.Path:
+				m.PicturesDir = ""
+			case strings.HasPrefix(f.Field, "formats."):
+				name := strings.TrimPrefix(f.Field, "formats.")
+				if m.Formats[name] == f.Path {
+					delete(m.Formats, name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,699 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError is an error in the JavaScript input, at a 1-based line and
+// column counted in characters.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// DefaultVar is the variable ParseJS picks when the input declares several.
+const DefaultVar = "movies"
+
+// ParseJS converts the value of a JavaScript variable (var movies = [...];)
+// into JSON. When the input declares several variables it picks
+// DefaultVar, or else the first one.
+func ParseJS(input []byte) ([]byte, error) {
+	return ParseJSVar(input, "")
+}
+
+// ParseJSVar converts the value of the variable name into JSON, or picks
+// one as ParseJS does when name is empty.
+//
+// The input is a sequence of var, let or const declarations, optionally
+// exported, and of assignments such as window.movies = ..., each of
+// whose value is a literal: objects with bare, quoted or numeric keys,
+// arrays, strings in single, double or back quotes (without ${}
+// substitutions), numbers, true, false, null and undefined. Comments and
+// trailing commas are allowed.
+func ParseJSVar(input []byte, name string) ([]byte, error) {
+	p := &parser{lex: lexer{src: input, line: 1, col: 1}}
+	vars, err := p.program()
+	if err != nil {
+		return nil, err
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("no variable declaration found")
+	}
+	if name == "" {
+		name = vars[0].name
+		for _, v := range vars {
+			if v.name == DefaultVar {
+				name = DefaultVar
+				break
+			}
+		}
+	}
+	// A later assignment overrides an earlier one, as when run
+	var value []byte
+	for _, v := range vars {
+		if v.name == name {
+			value = v.value
+		}
+	}
+	if value == nil {
+		return nil, fmt.Errorf("variable %q not found", name)
+	}
+	return value, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	// text is the identifier, the punctuation, the decoded string or the
+	// number in JSON.
+	text      string
+	line, col int
+	// endLine is the line the token ends on.
+	endLine int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	case tokNumber:
+		return "number " + t.text
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexer splits JavaScript source into tokens, skipping whitespace and
+// comments.
+type lexer struct {
+	src       []byte
+	pos       int
+	line, col int
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// peek returns the character at the current position, or -1 at the end.
+func (l *lexer) peek() rune {
+	return l.peekAt(0)
+}
+
+func (l *lexer) peekAt(offset int) rune {
+	pos := l.pos
+	for ; offset > 0 && pos < len(l.src); offset-- {
+		_, size := utf8.DecodeRune(l.src[pos:])
+		pos += size
+	}
+	if pos >= len(l.src) {
+		return -1
+	}
+	r, _ := utf8.DecodeRune(l.src[pos:])
+	return r
+}
+
+func (l *lexer) advance() rune {
+	r, size := utf8.DecodeRune(l.src[l.pos:])
+	l.pos += size
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+// skipSpace skips whitespace and comments.
+func (l *lexer) skipSpace() error {
+	for {
+		switch r := l.peek(); {
+		case r == '\ufeff' || unicode.IsSpace(r):
+			l.advance()
+		case r == '/' && l.peekAt(1) == '/':
+			for l.peek() != -1 && l.peek() != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peekAt(1) == '*':
+			line, col := l.line, l.col
+			l.advance()
+			l.advance()
+			for !(l.peek() == '*' && l.peekAt(1) == '/') {
+				if l.peek() == -1 {
+					return l.errorf(line, col, "unterminated comment")
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	tok, err := l.scan()
+	tok.endLine = l.line
+	return tok, err
+}
+
+func (l *lexer) scan() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	tok := token{line: l.line, col: l.col}
+	r := l.peek()
+	switch {
+	case r == -1:
+		tok.kind = tokEOF
+		return tok, nil
+	case r == '"' || r == '\'' || r == '`':
+		s, err := l.string()
+		tok.kind, tok.text = tokString, s
+		return tok, err
+	case r >= '0' && r <= '9' || r == '.' && isDigit(l.peekAt(1)):
+		n, err := l.number()
+		tok.kind, tok.text = tokNumber, n
+		return tok, err
+	case isIdentStart(r):
+		var sb strings.Builder
+		for isIdentPart(l.peek()) {
+			sb.WriteRune(l.advance())
+		}
+		tok.kind, tok.text = tokIdent, sb.String()
+		return tok, nil
+	case strings.ContainsRune("{}[]:,;=.-+", r):
+		l.advance()
+		tok.kind, tok.text = tokPunct, string(r)
+		return tok, nil
+	}
+	return tok, l.errorf(tok.line, tok.col, "unexpected character %q", r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+// string reads a quoted string, decoding its escapes.
+func (l *lexer) string() (string, error) {
+	line, col := l.line, l.col
+	quote := l.advance()
+	var sb strings.Builder
+	for {
+		r := l.peek()
+		switch {
+		case r == -1 || r == '\n' && quote != '`':
+			return "", l.errorf(line, col, "unterminated string")
+		case r == quote:
+			l.advance()
+			return sb.String(), nil
+		case r == '$' && quote == '`' && l.peekAt(1) == '{':
+			return "", l.errorf(l.line, l.col, "template substitutions are not supported")
+		case r == '\\':
+			if err := l.escape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteRune(l.advance())
+		}
+	}
+}
+
+// escape decodes an escape sequence in a string.
+func (l *lexer) escape(sb *strings.Builder) error {
+	line, col := l.line, l.col
+	l.advance()
+	if l.peek() == -1 {
+		return l.errorf(line, col, "unterminated string")
+	}
+	r := l.advance()
+	switch r {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'v':
+		sb.WriteByte('\v')
+	case '0':
+		if isDigit(l.peek()) {
+			return l.errorf(line, col, "octal escapes are not supported")
+		}
+		sb.WriteByte(0)
+	case '\n':
+		// A line continuation
+	case '\r':
+		if l.peek() == '\n' {
+			l.advance()
+		}
+	case 'x':
+		code, err := l.hex(2)
+		if err != nil {
+			return l.errorf(line, col, "invalid \\x escape")
+		}
+		sb.WriteRune(rune(code))
+	case 'u':
+		code, err := l.unicodeEscape()
+		if err != nil {
+			return l.errorf(line, col, "invalid \\u escape")
+		}
+		sb.WriteRune(rune(code))
+	default:
+		if isDigit(r) {
+			return l.errorf(line, col, "octal escapes are not supported")
+		}
+		// \', \", \\, \/ and any other character stand for themselves
+		sb.WriteRune(r)
+	}
+	return nil
+}
+
+func (l *lexer) hex(n int) (int, error) {
+	var digits strings.Builder
+	for range n {
+		if l.peek() == -1 {
+			return 0, strconv.ErrSyntax
+		}
+		digits.WriteRune(l.advance())
+	}
+	code, err := strconv.ParseUint(digits.String(), 16, 32)
+	return int(code), err
+}
+
+// unicodeEscape reads the XXXX of \uXXXX or the X... of \u{X...}, joining
+// a UTF-16 surrogate pair written as two escapes.
+func (l *lexer) unicodeEscape() (int, error) {
+	if l.peek() == '{' {
+		l.advance()
+		var digits strings.Builder
+		for l.peek() != '}' {
+			if l.peek() == -1 {
+				return 0, strconv.ErrSyntax
+			}
+			digits.WriteRune(l.advance())
+		}
+		l.advance()
+		code, err := strconv.ParseUint(digits.String(), 16, 32)
+		if err != nil || code > unicode.MaxRune {
+			return 0, strconv.ErrSyntax
+		}
+		return int(code), nil
+	}
+	code, err := l.hex(4)
+	if err != nil {
+		return 0, err
+	}
+	if code >= 0xd800 && code < 0xdc00 && l.peek() == '\\' && l.peekAt(1) == 'u' {
+		save := *l
+		l.advance()
+		l.advance()
+		if low, err := l.hex(4); err == nil && low >= 0xdc00 && low < 0xe000 {
+			return (code-0xd800)<<10 + (low - 0xdc00) + 0x10000, nil
+		}
+		*l = save
+	}
+	return code, nil
+}
+
+// number reads a numeric literal and returns it in JSON.
+func (l *lexer) number() (string, error) {
+	line, col := l.line, l.col
+	var sb strings.Builder
+	for {
+		r := l.peek()
+		// A sign follows the e of an exponent, which is a digit in hex
+		exponent := (r == '+' || r == '-') && strings.ContainsRune("eE", lastRune(sb.String())) &&
+			!strings.HasPrefix(strings.ToLower(sb.String()), "0x")
+		if !isIdentPart(r) && r != '.' && !exponent {
+			break
+		}
+		sb.WriteRune(l.advance())
+	}
+	text := sb.String()
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return strconv.FormatInt(n, 10), nil
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+	if err != nil || math.IsInf(f, 0) || strings.ContainsAny(text, "xXpP") {
+		return "", l.errorf(line, col, "invalid number %q", text)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// variable is a declared or assigned variable and its value in JSON.
+type variable struct {
+	name  string
+	value []byte
+}
+
+// parser reads the declarations of a program, converting their values to
+// JSON.
+type parser struct {
+	lex lexer
+	tok token
+	// peeked is set when tok was read ahead and not consumed yet.
+	peeked bool
+	// prevLine is the line the last consumed token ends on.
+	prevLine int
+}
+
+func (p *parser) next() (token, error) {
+	tok, err := p.peek()
+	if err != nil {
+		return tok, err
+	}
+	p.peeked = false
+	p.prevLine = tok.endLine
+	return tok, nil
+}
+
+func (p *parser) peek() (token, error) {
+	if !p.peeked {
+		var err error
+		if p.tok, err = p.lex.next(); err != nil {
+			return p.tok, err
+		}
+		p.peeked = true
+	}
+	return p.tok, nil
+}
+
+func (p *parser) unexpected(tok token, want string) error {
+	return &SyntaxError{Line: tok.line, Column: tok.col, Msg: fmt.Sprintf("unexpected %s, expected %s", tok, want)}
+}
+
+func (p *parser) expect(punct, want string) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok.kind != tokPunct || tok.text != punct {
+		return p.unexpected(tok, want)
+	}
+	return nil
+}
+
+func (p *parser) program() ([]variable, error) {
+	var vars []variable
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == tokEOF:
+			return vars, nil
+		case tok.kind == tokPunct && tok.text == ";":
+			continue
+		case tok.kind != tokIdent:
+			return nil, p.unexpected(tok, "a declaration")
+		}
+		if tok.text == "export" {
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+			if tok.kind != tokIdent || !isDeclaration(tok.text) {
+				return nil, p.unexpected(tok, "var, let or const")
+			}
+		}
+
+		if isDeclaration(tok.text) {
+			for {
+				v, err := p.declarator()
+				if err != nil {
+					return nil, err
+				}
+				if v.value != nil {
+					vars = append(vars, v)
+				}
+				if tok, err = p.peek(); err != nil {
+					return nil, err
+				}
+				if tok.kind != tokPunct || tok.text != "," {
+					break
+				}
+				p.next()
+			}
+		} else {
+			v, err := p.assignment(tok)
+			if err != nil {
+				return nil, err
+			}
+			vars = append(vars, v)
+		}
+		if err := p.endStatement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func isDeclaration(keyword string) bool {
+	return keyword == "var" || keyword == "let" || keyword == "const"
+}
+
+// endStatement reads the semicolon, or the line break or end of input a
+// semicolon may be left out before.
+func (p *parser) endStatement() error {
+	tok, err := p.peek()
+	if err != nil {
+		return err
+	}
+	switch {
+	case tok.kind == tokPunct && tok.text == ";":
+		p.next()
+	case tok.kind == tokEOF || tok.line > p.prevLine:
+	default:
+		return p.unexpected(tok, `";"`)
+	}
+	return nil
+}
+
+// declarator reads name = value, or a bare name, which has no value.
+func (p *parser) declarator() (variable, error) {
+	tok, err := p.next()
+	if err != nil {
+		return variable{}, err
+	}
+	if tok.kind != tokIdent {
+		return variable{}, p.unexpected(tok, "a variable name")
+	}
+	v := variable{name: tok.text}
+	if next, err := p.peek(); err != nil {
+		return v, err
+	} else if next.kind != tokPunct || next.text != "=" {
+		return v, nil
+	}
+	p.next()
+	v.value, err = p.value()
+	return v, err
+}
+
+// assignment reads name = value, where name may be a property such as
+// window.movies, which is named after its last part.
+func (p *parser) assignment(first token) (variable, error) {
+	v := variable{name: first.text}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return v, err
+		}
+		if tok.kind == tokPunct && tok.text == "=" {
+			break
+		}
+		if tok.kind != tokPunct || tok.text != "." {
+			return v, p.unexpected(tok, `"="`)
+		}
+		if tok, err = p.next(); err != nil {
+			return v, err
+		}
+		if tok.kind != tokIdent {
+			return v, p.unexpected(tok, "a property name")
+		}
+		v.name = tok.text
+	}
+	var err error
+	v.value, err = p.value()
+	return v, err
+}
+
+// value reads a literal and returns it in JSON.
+func (p *parser) value() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.writeValue(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *parser) writeValue(buf *bytes.Buffer) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	switch tok.kind {
+	case tokString:
+		writeString(buf, tok.text)
+		return nil
+	case tokNumber:
+		buf.WriteString(tok.text)
+		return nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false", "null":
+			buf.WriteString(tok.text)
+			return nil
+		case "undefined":
+			buf.WriteString("null")
+			return nil
+		}
+	case tokPunct:
+		switch tok.text {
+		case "{":
+			return p.writeObject(buf)
+		case "[":
+			return p.writeArray(buf)
+		case "-", "+":
+			num, err := p.next()
+			if err != nil {
+				return err
+			}
+			if num.kind != tokNumber {
+				return p.unexpected(num, "a number")
+			}
+			if tok.text == "-" {
+				buf.WriteByte('-')
+			}
+			buf.WriteString(num.text)
+			return nil
+		}
+	}
+	return p.unexpected(tok, "a value")
+}
+
+func (p *parser) writeObject(buf *bytes.Buffer) error {
+	buf.WriteByte('{')
+	for n := 0; ; n++ {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokPunct && tok.text == "}" {
+			buf.WriteByte('}')
+			return nil
+		}
+		if n > 0 {
+			if tok.kind != tokPunct || tok.text != "," {
+				return p.unexpected(tok, `"," or "}"`)
+			}
+			// A trailing comma
+			if tok, err = p.next(); err != nil {
+				return err
+			}
+			if tok.kind == tokPunct && tok.text == "}" {
+				buf.WriteByte('}')
+				return nil
+			}
+			buf.WriteByte(',')
+		}
+		switch tok.kind {
+		case tokIdent, tokString, tokNumber:
+			writeString(buf, tok.text)
+		default:
+			return p.unexpected(tok, "a property name")
+		}
+		if err := p.expect(":", `":"`); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := p.writeValue(buf); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) writeArray(buf *bytes.Buffer) error {
+	buf.WriteByte('[')
+	for n := 0; ; n++ {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokPunct && tok.text == "]" {
+			p.next()
+			buf.WriteByte(']')
+			return nil
+		}
+		if n > 0 {
+			if tok.kind != tokPunct || tok.text != "," {
+				return p.unexpected(tok, `"," or "]"`)
+			}
+			p.next()
+			// A trailing comma
+			if tok, err = p.peek(); err != nil {
+				return err
+			}
+			if tok.kind == tokPunct && tok.text == "]" {
+				p.next()
+				buf.WriteByte(']')
+				return nil
+			}
+			buf.WriteByte(',')
+		}
+		if tok.kind == tokPunct && tok.text == "," {
+			return &SyntaxError{Line: tok.line, Column: tok.col, Msg: "array holes are not supported"}
+		}
+		if err := p.writeValue(buf); err != nil {
+			return err
+		}
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode ends with a newline
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1 @@
+var movies = [1,, 2];
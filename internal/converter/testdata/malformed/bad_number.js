@@ -0,0 +1,3 @@
+var movies = [
+  {id: "a1", count: 12abc},
+];
@@ -0,0 +1 @@
+var movies = [] var other = [];
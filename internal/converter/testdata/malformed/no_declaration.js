@@ -0,0 +1 @@
+// only a comment
@@ -0,0 +1 @@
+movies.push({id: "a1"});
@@ -0,0 +1,3 @@
+var movies = [
+  {id: "a1", title: `${name}`},
+];
@@ -0,0 +1,2 @@
+var movies = [
+  {id: "a1", title: "t"}
@@ -0,0 +1,3 @@
+var movies = [
+  /* a comment that never ends
+];
@@ -0,0 +1,25 @@
+/* Generated by the upstream site.
+   Some titles contain { key: "value" } text. */
+var count = 2, updated = '2024-06-01';
+// The list itself
+var movies = [
+  {
+    id: 'q1',
+    dir: "quoted/dir/",
+    jpg: 'it\'s.jpg', // an escaped quote
+    detail: `pics/`,
+    title: 'A { fake: "key" }, with, commas',
+    url: "https://example.com/q1?a=1&b=2",
+    date: "2024-01-15",
+    actors: ['Actor "A"', "Actor\u0020B", `Actor C`,],
+    tags: ["line\nbreak", 'tab\there', "emoji \u{1F600}", "\uD83C\uDFAC"],
+    formats: {
+      '720p': 'video_720p.mp4',
+      1080: "video_1080p.mp4",
+    },
+    rating: -1.5e1,
+    views: 0x10,
+    hidden: undefined,
+  },
+];
+const other = { title: "not a movie list" };
@@ -0,0 +1,89 @@
+package converter
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"time"
+)
+
+// WatchOptions configure Watch.
+type WatchOptions struct {
+	// Interval is how often the file is polled.
+	Interval time.Duration
+	// Debounce is how long the file must stay unchanged after a change
+	// before it is read, so that a file being rewritten is read once.
+	Debounce time.Duration
+}
+
+// retryError marks an error of a Watch callback as transient.
+type retryError struct {
+	err error
+}
+
+func (e *retryError) Error() string { return e.err.Error() }
+
+func (e *retryError) Unwrap() error { return e.err }
+
+// Retry wraps a transient error of a Watch callback, such as a busy
+// database, so that the same content is passed again at the next poll.
+// Other errors wait for the content to change.
+func Retry(err error) error {
+	return &retryError{err: err}
+}
+
+// fileState is what polling compares to detect a change.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// Watch polls the file at path and calls fn with its content, first right
+// away and then after every change that settled for opts.Debounce. A
+// content identical to the last one passed to fn, byte for byte, is
+// skipped, unless fn returned an error wrapped by Retry for it. It runs
+// until ctx is done and returns its error. A file that cannot be read is
+// retried at the next poll too. opts.Interval must be positive.
+func Watch(ctx context.Context, path string, opts WatchOptions, fn func([]byte) error) error {
+	if opts.Interval <= 0 {
+		return errors.New("non-positive watch interval")
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	last, _ := statFile(path)
+	var changed time.Time
+	var hash [sha256.Size]byte
+	pending, seen := true, false
+	for {
+		state, err := statFile(path)
+		if state != last {
+			last, changed, pending = state, time.Now(), true
+		}
+		if pending && err == nil && time.Since(changed) >= opts.Debounce {
+			if data, err := os.ReadFile(path); err == nil {
+				sum := sha256.Sum256(data)
+				if seen && sum == hash {
+					pending = false
+				} else if err := fn(data); !errors.As(err, new(*retryError)) {
+					hash, seen, pending = sum, true, false
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
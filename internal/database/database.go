@@ -2,20 +2,27 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 type DB = sql.DB
 
 func New(dsn string) (*DB, error) {
-	db, err := sql.Open("sqlite", dsn)
-	if err != nil {
-		return nil, err
+	// Pragmas in the DSN are applied to every connection in the pool, which
+	// ON DELETE CASCADE relies on. The busy timeout lets requests wait for
+	// background import jobs instead of failing with SQLITE_BUSY. In WAL
+	// mode a long read, such as an export, does not block imports.
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
 	}
-
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
+	db, err := sql.Open("sqlite", dsn+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
 		return nil, err
 	}
 
@@ -28,6 +35,60 @@ func New(dsn string) (*DB, error) {
 }
 
 func RunMigrations(db *DB) error {
-	_, err := db.Exec(migrations)
-	return err
+	if _, err := db.Exec(migrations); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(upgrades); i++ {
+		if err := runUpgrade(db, i+1, upgrades[i]); err != nil {
+			return fmt.Errorf("upgrade %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func runUpgrade(db *DB, version int, upgrade string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(upgrade); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EscapeLike escapes the wildcards of s for a LIKE pattern with ESCAPE '\'.
+func EscapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// TouchUpdatedAt is the new updated_at of a video being written. It has
+// millisecond precision and always moves forward, even for writes within
+// the same millisecond, so that updated_at can serve as the video's ETag.
+const TouchUpdatedAt = "max(strftime('%Y-%m-%d %H:%M:%f', 'now'), strftime('%Y-%m-%d %H:%M:%f', updated_at, '+0.001 seconds'))"
+
+// IsBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED, which
+// another connection holding the database causes and which a retry may not
+// hit.
+func IsBusy(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	switch serr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
 }
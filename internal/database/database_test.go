@@ -1,6 +1,8 @@
 package database
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
 )
 
@@ -90,3 +92,82 @@ func TestForeignKeysEnabled(t *testing.T) {
 		t.Errorf("expected foreign_keys to be enabled (1), got %d", fk)
 	}
 }
+
+func TestUpgradeRebuildsFTS(t *testing.T) {
+	db, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Recreate the schema of a database from before the trigram upgrade
+	queries := []string{
+		"DROP TABLE videos_fts",
+		"CREATE VIRTUAL TABLE videos_fts USING fts5(video_id, title, actors, tags)",
+		"DROP TABLE videos",
+		`CREATE TABLE videos (id TEXT PRIMARY KEY, title TEXT NOT NULL, url TEXT NOT NULL DEFAULT '', date TEXT NOT NULL DEFAULT '',
+			jpg TEXT NOT NULL DEFAULT '', pictures_dir TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		"DROP TABLE actor_aliases",
+		"DROP TABLE actors",
+		"CREATE TABLE actors (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE)",
+		"PRAGMA user_version = 0",
+		"INSERT INTO videos (id, title) VALUES ('test1', 'ﾃｽﾄ動画')",
+		"INSERT INTO actors (name) VALUES ('出演者Ａ')",
+		"INSERT INTO video_actors (video_id, actor_id) VALUES ('test1', 1)",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("failed to prepare old schema: %v\nquery: %s", err, q)
+		}
+	}
+
+	if err := RunMigrations(db); err != nil {
+		t.Fatalf("failed to upgrade: %v", err)
+	}
+
+	var title, actors string
+	err = db.QueryRow("SELECT title, actors FROM videos_fts WHERE videos_fts MATCH 'テスト'").Scan(&title, &actors)
+	if err != nil {
+		t.Fatalf("expected normalized fts row: %v", err)
+	}
+	if title != "テスト動画" {
+		t.Errorf("expected normalized title テスト動画, got %s", title)
+	}
+	if actors != "出演者a" {
+		t.Errorf("expected normalized actors 出演者a, got %s", actors)
+	}
+
+	var version int
+	db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != len(upgrades) {
+		t.Errorf("expected user_version %d, got %d", len(upgrades), version)
+	}
+}
+
+func TestNewEnablesForeignKeysOnEveryConnection(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Hold two connections open at once so that the pool cannot hand out
+	// the same one twice.
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("failed to get connection: %v", err)
+		}
+		defer conn.Close()
+
+		var enabled int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			t.Fatalf("failed to read pragma: %v", err)
+		}
+		if enabled != 1 {
+			t.Errorf("connection %d: expected foreign_keys=1, got %d", i, enabled)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// Execer is implemented by both *sql.DB and *sql.Tx.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// ftsRows indexes each actor by name, readings and aliases, so that any of
+// them finds the actor's videos.
+const ftsRows = `INSERT INTO videos_fts (video_id, title, actors, tags)
+	SELECT v.id, normalize_text(v.title),
+		normalize_text(COALESCE((SELECT group_concat(concat_ws(' ', a.name, NULLIF(a.kana, ''), NULLIF(a.romaji, ''),
+			(SELECT group_concat(aa.name, ' ') FROM actor_aliases aa WHERE aa.actor_id = a.id)), ',')
+			FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE va.video_id = v.id), '')),
+		normalize_text(COALESCE((SELECT group_concat(t.name, ',') FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE vt.video_id = v.id), ''))
+	FROM videos v`
+
+// IndexVideo rebuilds the videos_fts row of a video from its current title,
+// actors and tags.
+func IndexVideo(ex Execer, videoID string) error {
+	if _, err := ex.Exec("DELETE FROM videos_fts WHERE video_id = $1", videoID); err != nil {
+		return err
+	}
+	_, err := ex.Exec(ftsRows+" WHERE v.id = $1", videoID)
+	return err
+}
+
+// RebuildFTS rebuilds videos_fts for every video.
+func RebuildFTS(ex Execer) error {
+	if _, err := ex.Exec("DELETE FROM videos_fts"); err != nil {
+		return err
+	}
+	_, err := ex.Exec(ftsRows)
+	return err
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/iwaco/movies/internal/textnorm"
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// normalize_text lets SQL build videos_fts rows with the same
+	// normalization that is applied to search queries.
+	sqlite.MustRegisterDeterministicScalarFunction("normalize_text", 1,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			s, _ := args[0].(string)
+			return textnorm.Normalize(s), nil
+		})
+
+	// shuffle_key(seed, id) orders rows pseudo-randomly; the order is the
+	// same for every query that uses the same seed. Keys stay below 2^53 so
+	// that they survive the JSON round trip through a list cursor.
+	sqlite.MustRegisterDeterministicScalarFunction("shuffle_key", 2,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			seed, _ := args[0].(int64)
+			id, _ := args[1].(string)
+			return shuffleKey(seed, id), nil
+		})
+}
+
+func shuffleKey(seed int64, id string) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	h.Write([]byte(id))
+	return int64(h.Sum64() >> 11)
+}
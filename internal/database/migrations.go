@@ -50,5 +50,80 @@ CREATE TABLE IF NOT EXISTS ratings (
     updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(video_id, title, actors, tags);
+CREATE TABLE IF NOT EXISTS tag_aliases (
+    name TEXT PRIMARY KEY,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS actor_aliases (
+    name TEXT PRIMARY KEY,
+    actor_id INTEGER NOT NULL REFERENCES actors(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id TEXT PRIMARY KEY,
+    state TEXT NOT NULL,
+    options TEXT NOT NULL DEFAULT '{}',
+    result TEXT NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    finished_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_created_at ON import_jobs(created_at);
+
+CREATE TABLE IF NOT EXISTS import_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL DEFAULT '',
+    options TEXT NOT NULL DEFAULT '{}',
+    payload_hash TEXT NOT NULL DEFAULT '',
+    records INTEGER NOT NULL DEFAULT 0,
+    created INTEGER NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    unchanged INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    removed INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    started_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS video_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL REFERENCES import_runs(id) ON DELETE CASCADE,
+    video_id TEXT NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    changed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_video_changes_video_id ON video_changes(video_id);
+` + videosFTS
+
+// videos_fts holds normalized text (see textnorm) and uses the trigram
+// tokenizer so that Japanese titles can be searched by substring.
+const videosFTS = `
+CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(video_id UNINDEXED, title, actors, tags, tokenize='trigram');
 `
+
+// upgrades bring databases created by older versions up to the current
+// schema. PRAGMA user_version records how many of them have been applied.
+var upgrades = []string{
+	// 1: recreate videos_fts with the trigram tokenizer. Upgrade 2 fills it.
+	"DROP TABLE IF EXISTS videos_fts;" + videosFTS,
+	// 2: actor profiles, whose readings and aliases are indexed with the
+	// actor names.
+	`ALTER TABLE actors ADD COLUMN kana TEXT NOT NULL DEFAULT '';
+	ALTER TABLE actors ADD COLUMN romaji TEXT NOT NULL DEFAULT '';
+	ALTER TABLE actors ADD COLUMN image_path TEXT NOT NULL DEFAULT '';
+	ALTER TABLE actors ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+	DELETE FROM videos_fts;` + ftsRows + ";",
+	// 3: the source label of imported videos, and soft deletion by sync
+	// imports. Soft-deleted videos keep their videos_fts rows.
+	`ALTER TABLE videos ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN deleted_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_videos_source ON videos(source);`,
+	// 4: the hash of the last imported record, to skip unchanged records.
+	"ALTER TABLE videos ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';",
+}
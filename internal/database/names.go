@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Querier is implemented by both *sql.DB and *sql.Tx.
+type Querier interface {
+	Execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// NameTable is a table of uniquely named rows that videos link to, such as
+// tags, together with the table of aliases that resolve to its rows.
+type NameTable struct {
+	Table      string
+	AliasTable string
+	// AliasKey is the column of AliasTable referencing Table.
+	AliasKey string
+}
+
+var (
+	Actors = NameTable{Table: "actors", AliasTable: "actor_aliases", AliasKey: "actor_id"}
+	Tags   = NameTable{Table: "tags", AliasTable: "tag_aliases", AliasKey: "tag_id"}
+)
+
+// Lookup returns the ID of the row called name, or of the row name is an
+// alias of. It returns sql.ErrNoRows when there is neither. Aliases also
+// match after normalize_text, so that one alias covers the case and width
+// variants of a name, but an exact alias or name comes first.
+func (n NameTable) Lookup(q Querier, name string) (int, error) {
+	var id int
+	if n.AliasTable != "" {
+		err := q.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE name = $1", n.AliasKey, n.AliasTable), name).Scan(&id)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return id, err
+		}
+	}
+	err := q.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE name = $1", n.Table), name).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) || n.AliasTable == "" {
+		return id, err
+	}
+	err = q.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE normalize_text(name) = normalize_text($1) ORDER BY name LIMIT 1",
+		n.AliasKey, n.AliasTable), name).Scan(&id)
+	return id, err
+}
+
+// Resolve is Lookup, creating a row called name when there is none.
+func (n NameTable) Resolve(q Querier, name string) (int, error) {
+	id, err := n.Lookup(q, name)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	if _, err := q.Exec(fmt.Sprintf("INSERT INTO %s (name) VALUES ($1)", n.Table), name); err != nil {
+		return 0, err
+	}
+	return n.Lookup(q, name)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/iwaco/movies/internal/model"
+	"github.com/iwaco/movies/internal/repository"
+)
+
+type ActorHandler struct {
+	repo      *repository.ActorRepository
+	mediaRoot string
+}
+
+func NewActorHandler(repo *repository.ActorRepository, mediaRoot string) *ActorHandler {
+	return &ActorHandler{repo: repo, mediaRoot: mediaRoot}
+}
+
+func (h *ActorHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	actor, err := h.repo.Get(id)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, actor)
+}
+
+// Update updates an actor's name and profile. A new name keeps the old one
+// as an alias; image_path must name a file under the media root.
+func (h *ActorHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req model.ActorUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "name must not be empty", http.StatusBadRequest)
+			return
+		}
+		req.Name = &name
+	}
+	if req.ImagePath != nil && *req.ImagePath != "" {
+		rel := strings.TrimPrefix(*req.ImagePath, "/")
+		if !filepath.IsLocal(rel) {
+			http.Error(w, "image_path must be under the media root", http.StatusBadRequest)
+			return
+		}
+		if info, err := os.Stat(filepath.Join(h.mediaRoot, rel)); err != nil || info.IsDir() {
+			http.Error(w, "image_path does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
+	actor, err := h.repo.Update(id, req)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, actor)
+}
+
+// Merge merges the actors listed in actor_ids into the actor in the URL.
+func (h *ActorHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		ActorIDs []int `json:"actor_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.ActorIDs) == 0 {
+		http.Error(w, "actor_ids must not be empty", http.StatusBadRequest)
+		return
+	}
+	actor, err := h.repo.Merge(id, req.ActorIDs)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, actor)
+}
+
+func (h *ActorHandler) AddAlias(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	name, ok := decodeName(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.AddAlias(id, name); err != nil {
+		writeNameError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ActorHandler) RemoveAlias(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.repo.RemoveAlias(id, chi.URLParam(r, "alias")); err != nil {
+		writeNameError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/iwaco/movies/internal/importer"
+	"github.com/iwaco/movies/internal/model"
+	"github.com/iwaco/movies/internal/repository"
+)
+
+// exportBatchSize is the number of videos read per query while exporting.
+const exportBatchSize = 500
+
+type ExportHandler struct {
+	repo *repository.VideoRepository
+}
+
+func NewExportHandler(repo *repository.VideoRepository) *ExportHandler {
+	return &ExportHandler{repo: repo}
+}
+
+// Export streams every video matching the same filters and sort as List,
+// ignoring pagination, in a format the import endpoint reads back: ndjson
+// (the default) or json records, or a csv or tsv table. With ratings=true
+// the json and ndjson records include the ratings.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	params, err := parseVideoQueryParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var ratings bool
+	switch q.Get("ratings") {
+	case "", "false":
+	case "true":
+		ratings = true
+	default:
+		http.Error(w, "invalid ratings parameter", http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = importer.FormatNDJSON
+	}
+	switch format {
+	case importer.FormatJSON, importer.FormatNDJSON:
+	case importer.FormatCSV, importer.FormatTSV:
+		if ratings {
+			http.Error(w, "ratings are only exported as json or ndjson", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "invalid format parameter", http.StatusBadRequest)
+		return
+	}
+
+	// The format columns of a table are read in the same snapshot as the
+	// rows, so that every format of the rows has a column
+	out := &startWriter{w: w}
+	err = h.repo.Snapshot(func(repo *repository.VideoRepository) error {
+		var write func(model.Video) error
+		var finish func() error
+		switch format {
+		case importer.FormatJSON, importer.FormatNDJSON:
+			jw, err := importer.NewJSONWriter(out, format, ratings)
+			if err != nil {
+				return err
+			}
+			write, finish = jw.Write, jw.Close
+			if format == importer.FormatJSON {
+				w.Header().Set("Content-Type", "application/json")
+			} else {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="videos.`+format+`"`)
+		default:
+			facets, err := repo.Facets(params, []string{"format"})
+			if err != nil {
+				return err
+			}
+			var formats []string
+			for _, f := range facets.Facets["format"] {
+				formats = append(formats, f.Value)
+			}
+			comma, contentType := ',', "text/csv; charset=utf-8"
+			if format == importer.FormatTSV {
+				comma, contentType = '\t', "text/tab-separated-values; charset=utf-8"
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="videos.`+format+`"`)
+			cw, err := importer.NewCSVWriter(out, comma, formats)
+			if err != nil {
+				return err
+			}
+			write, finish = cw.Write, cw.Flush
+		}
+
+		if err := repo.Each(params, exportBatchSize, write); err != nil {
+			return err
+		}
+		return finish()
+	})
+	if err != nil {
+		if !out.started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status is already sent, so the export is left truncated
+		log.Printf("export: %v", err)
+	}
+}
+
+// startWriter records whether anything was written to the response, after
+// which an error can no longer change its status.
+type startWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if n > 0 {
+		s.started = true
+	}
+	return n, err
+}
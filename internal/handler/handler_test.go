@@ -2,12 +2,17 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/iwaco/movies/internal/database"
@@ -28,21 +33,44 @@ func setupTestRouterWithMediaRoot(t *testing.T, mediaRoot string) (*chi.Mux, *da
 
 	videoRepo := repository.NewVideoRepository(db)
 	ratingRepo := repository.NewRatingRepository(db)
+	tagRepo := repository.NewTagRepository(db)
+	actorRepo := repository.NewActorRepository(db)
 	imp := importer.New(db)
 
 	vh := NewVideoHandler(videoRepo, mediaRoot)
 	rh := NewRatingHandler(ratingRepo)
-	ih := NewImportHandler(imp)
+	th := NewTagHandler(tagRepo)
+	ah := NewActorHandler(actorRepo, mediaRoot)
+	ih := NewImportHandler(imp, importer.NewJobs(imp, t.TempDir()), 500, 10)
+	eh := NewExportHandler(videoRepo)
 
 	r := chi.NewRouter()
 	r.Get("/api/v1/videos", vh.List)
+	r.Get("/api/v1/videos/facets", vh.Facets)
 	r.Get("/api/v1/videos/{id}", vh.GetByID)
+	r.Patch("/api/v1/videos/{id}", vh.Update)
+	r.Delete("/api/v1/videos/{id}", vh.Delete)
+	r.Delete("/api/v1/videos", vh.DeleteMany)
+	r.Post("/api/v1/prune", vh.Prune)
 	r.Get("/api/v1/videos/{id}/pictures", vh.GetPictures)
 	r.Get("/api/v1/tags", vh.ListTags)
+	r.Patch("/api/v1/tags/{id}", th.Rename)
+	r.Post("/api/v1/tags/{id}/merge", th.Merge)
+	r.Get("/api/v1/tags/{id}/aliases", th.ListAliases)
+	r.Post("/api/v1/tags/{id}/aliases", th.AddAlias)
+	r.Delete("/api/v1/tags/{id}/aliases/{alias}", th.RemoveAlias)
 	r.Get("/api/v1/actors", vh.ListActors)
+	r.Get("/api/v1/actors/{id}", ah.Get)
+	r.Patch("/api/v1/actors/{id}", ah.Update)
+	r.Post("/api/v1/actors/{id}/merge", ah.Merge)
+	r.Post("/api/v1/actors/{id}/aliases", ah.AddAlias)
+	r.Delete("/api/v1/actors/{id}/aliases/{alias}", ah.RemoveAlias)
 	r.Put("/api/v1/ratings/{videoID}", rh.Set)
 	r.Delete("/api/v1/ratings/{videoID}", rh.Remove)
 	r.Post("/api/v1/import", ih.Import)
+	r.Get("/api/v1/import/runs", ih.ListRuns)
+	r.Get("/api/v1/videos/{id}/history", ih.VideoHistory)
+	r.Get("/api/v1/export", eh.Export)
 
 	return r, db
 }
@@ -59,16 +87,15 @@ func seedHandlerTestData(t *testing.T, db *database.DB) {
 		`INSERT INTO video_actors (video_id, actor_id) VALUES ('vid1', 1), ('vid2', 2)`,
 		`INSERT INTO video_tags (video_id, tag_id) VALUES ('vid1', 1), ('vid2', 2)`,
 		`INSERT INTO video_formats (video_id, name, file_path) VALUES ('vid1', '720p', '/720p.mp4'), ('vid2', '480p', '/480p.mp4')`,
-		`INSERT INTO videos_fts (video_id, title, actors, tags) VALUES
-			('vid1', 'First Video', 'Actor A', 'tag1'),
-			('vid2', 'Second Video', 'Actor B', 'tag2'),
-			('vid3', 'Third Video', '', '')`,
 	}
 	for _, q := range queries {
 		if _, err := db.Exec(q); err != nil {
 			t.Fatalf("failed to seed: %v\nquery: %s", err, q)
 		}
 	}
+	if err := database.RebuildFTS(db); err != nil {
+		t.Fatalf("failed to index: %v", err)
+	}
 }
 
 func TestListVideos(t *testing.T) {
@@ -195,6 +222,48 @@ func TestListActors(t *testing.T) {
 	}
 }
 
+func TestListTagsParams(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/tags?sort=count&limit=1&hide_empty=true")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	var result struct {
+		Tags []struct {
+			Name       string `json:"name"`
+			VideoCount int    `json:"video_count"`
+		} `json:"tags"`
+		Total int `json:"total"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if len(result.Tags) != 1 || result.Tags[0].VideoCount != 1 || result.Total != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	for _, query := range []string{"sort=popular", "limit=-1", "offset=x", "hide_empty=yes"} {
+		for _, path := range []string{"/api/v1/tags", "/api/v1/actors"} {
+			resp, err := http.Get(ts.URL + path + "?" + query)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s?%s: expected 400, got %d", path, query, resp.StatusCode)
+			}
+		}
+	}
+}
+
 func TestSetAndRemoveRating(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
@@ -283,105 +352,201 @@ func TestImportHandler(t *testing.T) {
 	}
 }
 
-func TestGetPictures_NotFound(t *testing.T) {
+func TestImportNDJSON(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos/nonexistent/pictures")
+	body := "{\"id\": \"n1\", \"title\": \"One\"}\n{\"id\": \"n2\", \"title\": \"Two\"}\n{\"id\": \"n3\", \"title\": \"Three\"}\n"
+	resp, err := http.Post(ts.URL+"/api/v1/import?batch_size=2", "application/x-ndjson", bytes.NewBufferString(body))
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNotFound {
-		t.Errorf("expected 404, got %d", resp.StatusCode)
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if result["records"] != float64(3) || result["imported"] != float64(3) || result["batches"] != float64(2) {
+		t.Errorf("unexpected counts: %v", result)
 	}
-}
-
-func TestGetPictures_EmptyArray(t *testing.T) {
-	r, db := setupTestRouter(t)
-	defer db.Close()
-	seedHandlerTestData(t, db)
-
-	ts := httptest.NewServer(r)
-	defer ts.Close()
 
-	// vid1 exists but /test-media directory does not, so pictures should be []
-	resp, err := http.Get(ts.URL + "/api/v1/videos/vid1/pictures")
+	// A malformed record is a 400 that still reports what was committed
+	resp, err = http.Post(ts.URL+"/api/v1/import?format=ndjson&batch_size=1", "text/plain", bytes.NewBufferString("{\"id\": \"n4\", \"title\": \"Four\"}\nnot json\n"))
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
-	defer resp.Body.Close()
+	result = nil
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+	if result["imported"] != float64(1) || result["error"] == nil {
+		t.Errorf("expected counts and an error, got %v", result)
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+	for _, query := range []string{"format=xml", "batch_size=0", "batch_size=x"} {
+		resp, err := http.Post(ts.URL+"/api/v1/import?"+query, "application/json", bytes.NewBufferString("[]"))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
 	}
+}
 
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+// disconnectingBody is a request body whose client disconnects after the
+// first line: it cancels the request and fails the read.
+type disconnectingBody struct {
+	line   string
+	cancel context.CancelFunc
+}
 
-	pictures, ok := result["pictures"].([]interface{})
-	if !ok {
-		t.Fatalf("expected pictures to be an array, got %T (%v)", result["pictures"], result["pictures"])
-	}
-	if len(pictures) != 0 {
-		t.Errorf("expected 0 pictures, got %d", len(pictures))
+func (b *disconnectingBody) Read(p []byte) (int, error) {
+	if b.line != "" {
+		n := copy(p, b.line)
+		b.line = b.line[n:]
+		return n, nil
 	}
+	b.cancel()
+	return 0, io.ErrUnexpectedEOF
 }
 
-func TestGetPictures_WithImages(t *testing.T) {
-	// Create a temporary directory with test images
-	tmpDir := t.TempDir()
-	picsDir := filepath.Join(tmpDir, "pics", "vid1")
-	if err := os.MkdirAll(picsDir, 0755); err != nil {
-		t.Fatalf("failed to create pics dir: %v", err)
+func TestImportClientDisconnect(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := &disconnectingBody{line: "{\"id\": \"d1\", \"title\": \"One\"}\n", cancel: cancel}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/import?format=ndjson&strict=true", body).WithContext(ctx)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// The import stops as cancelled, not as malformed input
+	var result map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code == http.StatusBadRequest || !strings.Contains(fmt.Sprint(result["error"]), "context canceled") {
+		t.Errorf("expected a cancelled import, got %d: %v", w.Code, result)
 	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the strict import to be rolled back, got %d videos", count)
+	}
+}
 
-	// Create test image files
-	for _, name := range []string{"img1.jpg", "img2.png", "not_image.txt"} {
-		if err := os.WriteFile(filepath.Join(picsDir, name), []byte("fake"), 0644); err != nil {
-			t.Fatalf("failed to create test file: %v", err)
+func TestImportReport(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	body := `[{"id": "r1", "title": "One"}, {"id": "r2", "title": "Two", "date": "yesterday"}]`
+	tests := []struct {
+		query    string
+		expect   int
+		imported float64
+	}{
+		{"?strict=true", http.StatusUnprocessableEntity, 0},
+		{"", http.StatusOK, 1},
+		{"?strict=maybe", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+"/api/v1/import"+tt.query, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result struct {
+			Imported float64 `json:"imported"`
+			Failed   float64 `json:"failed"`
+			Issues   []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+				Reason string `json:"reason"`
+			} `json:"issues"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expect, resp.StatusCode)
+			continue
+		}
+		if tt.expect == http.StatusBadRequest {
+			continue
+		}
+		if result.Imported != tt.imported || result.Failed != 1 {
+			t.Errorf("%s: unexpected counts: %+v", tt.query, result)
+		}
+		if n := len(result.Issues); n == 0 || result.Issues[n-1].ID != "r2" || result.Issues[n-1].Status != "failed" {
+			t.Errorf("%s: expected r2 to be reported as failed, got %+v", tt.query, result.Issues)
 		}
 	}
+}
 
-	r, db := setupTestRouterWithMediaRoot(t, tmpDir)
+func TestImportDryRun(t *testing.T) {
+	r, db := setupTestRouter(t)
 	defer db.Close()
 	seedHandlerTestData(t, db)
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos/vid1/pictures")
+	body := `{"id": "vid1", "title": "First Video (HD)", "url": "https://example.com/1", "date": "2024-01-15", "jpg": "/thumb1.jpg", "pictures_dir": "/pics/vid1/", "actors": ["Actor A"], "tags": ["tag1", "tag2"], "formats": {"720p": "/720p.mp4"}}`
+	resp, err := http.Post(ts.URL+"/api/v1/import?dry_run=true", "application/x-ndjson", bytes.NewBufferString(body))
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
 	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Updated float64 `json:"updated"`
+		Diffs   []struct {
+			ID        string                       `json:"id"`
+			Fields    map[string]map[string]string `json:"fields"`
+			AddedTags []string                     `json:"added_tags"`
+		} `json:"diffs"`
+		Missing []string `json:"missing"`
 	}
-
-	var result map[string]interface{}
 	json.NewDecoder(resp.Body).Decode(&result)
+	if result.Updated != 1 || len(result.Diffs) != 1 {
+		t.Fatalf("expected one updated video, got %+v", result)
+	}
+	diff := result.Diffs[0]
+	if diff.ID != "vid1" || len(diff.Fields) != 1 || diff.Fields["title"]["new"] != "First Video (HD)" ||
+		len(diff.AddedTags) != 1 || diff.AddedTags[0] != "tag2" {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+	if len(result.Missing) != 2 || result.Missing[0] != "vid2" || result.Missing[1] != "vid3" {
+		t.Errorf("expected vid2 and vid3 to be missing, got %v", result.Missing)
+	}
 
-	pictures := result["pictures"].([]interface{})
-	if len(pictures) != 2 {
-		t.Fatalf("expected 2 pictures, got %d: %v", len(pictures), pictures)
+	var title string
+	db.QueryRow("SELECT title FROM videos WHERE id = 'vid1'").Scan(&title)
+	if title != "First Video" {
+		t.Errorf("expected the dry run to write nothing, got title %q", title)
 	}
 
-	// Verify paths start with /
-	for _, p := range pictures {
-		path := p.(string)
-		if path[0] != '/' {
-			t.Errorf("expected path to start with /, got %s", path)
-		}
+	resp, err = http.Post(ts.URL+"/api/v1/import?dry_run=yes", "application/json", bytes.NewBufferString("[]"))
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid dry_run, got %d", resp.StatusCode)
 	}
 }
 
-func TestListVideosHasVideoTrue(t *testing.T) {
+func TestImportSync(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
 	seedHandlerTestData(t, db)
@@ -389,122 +554,620 @@ func TestListVideosHasVideoTrue(t *testing.T) {
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=true")
-	if err != nil {
-		t.Fatalf("failed: %v", err)
+	body := `{"id": "vid1", "title": "First Video"}`
+	tests := []struct {
+		query   string
+		expect  int
+		removed int
+	}{
+		{"?mode=mirror", http.StatusBadRequest, 0},
+		{"?mode=sync&delete=purge", http.StatusBadRequest, 0},
+		{"?mode=sync&max_remove=101", http.StatusBadRequest, 0},
+		{"?mode=sync", http.StatusConflict, 0},
+		{"?mode=sync&prefix=vid3&max_remove=100", http.StatusOK, 1},
+		{"?mode=sync&force=true&delete=hard", http.StatusOK, 1},
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+"/api/v1/import"+tt.query, "application/x-ndjson", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result struct {
+			Removed int    `json:"removed"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expect, resp.StatusCode)
+		}
+		if result.Removed != tt.removed {
+			t.Errorf("%s: expected %d removed, got %+v", tt.query, tt.removed, result)
+		}
 	}
 
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+	var live, stored int
+	db.QueryRow("SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL").Scan(&live)
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&stored)
+	if live != 1 || stored != 2 {
+		t.Errorf("expected vid3 soft-deleted and vid2 deleted, got %d live of %d stored", live, stored)
+	}
+}
 
-	if result["total"].(float64) != 2 {
-		t.Errorf("expected 2 videos with formats, got %v", result["total"])
+// setupJobsTestRouter uses a database file, since jobs query it from other
+// goroutines and every connection to :memory: opens an empty database.
+func setupJobsTestRouter(t *testing.T) (*chi.Mux, *importer.Jobs, *database.DB) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
 	}
+	imp := importer.New(db)
+	jobs := importer.NewJobs(imp, t.TempDir())
+	ih := NewImportHandler(imp, jobs, 500, 10)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/import/jobs", ih.StartJob)
+	r.Get("/api/v1/import/jobs", ih.ListJobs)
+	r.Get("/api/v1/import/jobs/{id}", ih.GetJob)
+	r.Post("/api/v1/import/jobs/{id}/cancel", ih.CancelJob)
+	return r, jobs, db
 }
 
-func TestListVideosHasVideoFalse(t *testing.T) {
-	r, db := setupTestRouter(t)
+func TestImportJobs(t *testing.T) {
+	r, jobs, db := setupJobsTestRouter(t)
 	defer db.Close()
-	seedHandlerTestData(t, db)
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=false")
+	body := `{"id": "j1", "title": "One"}
+{"id": "j2", "title": "Two"}`
+	resp, err := http.Post(ts.URL+"/api/v1/import/jobs?batch_size=1", "application/x-ndjson", bytes.NewBufferString(body))
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
-	defer resp.Body.Close()
+	var job importer.Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/api/v1/import/jobs/"+job.ID {
+		t.Errorf("unexpected Location %q", loc)
+	}
+	jobs.Wait()
 
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+	resp, err = http.Get(ts.URL + "/api/v1/import/jobs/" + job.ID)
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if job.State != importer.JobCompleted || job.Result.Imported != 2 || job.Result.Batches != 2 {
+		t.Errorf("unexpected job: %+v", job)
 	}
 
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+	resp, err = http.Get(ts.URL + "/api/v1/import/jobs")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	var list struct {
+		Jobs []importer.Job `json:"jobs"`
+	}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != job.ID {
+		t.Errorf("unexpected jobs: %+v", list.Jobs)
+	}
 
-	if result["total"].(float64) != 3 {
-		t.Errorf("expected 3 videos (all), got %v", result["total"])
+	tests := []struct {
+		method string
+		path   string
+		expect int
+	}{
+		{"POST", "/api/v1/import/jobs/" + job.ID + "/cancel", http.StatusConflict},
+		{"POST", "/api/v1/import/jobs/missing/cancel", http.StatusNotFound},
+		{"GET", "/api/v1/import/jobs/missing", http.StatusNotFound},
+		{"GET", "/api/v1/import/jobs?limit=0", http.StatusBadRequest},
+		{"POST", "/api/v1/import/jobs?mode=mirror", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.expect, resp.StatusCode)
+		}
 	}
 }
 
-func TestListVideosHasVideoInvalid(t *testing.T) {
+func TestImportHistory(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
-	seedHandlerTestData(t, db)
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=invalid")
+	for _, body := range []string{
+		`{"id": "h1", "title": "One", "tags": ["a"]}`,
+		`{"id": "h1", "title": "One (HD)", "tags": ["a", "b"]}`,
+	} {
+		resp, err := http.Post(ts.URL+"/api/v1/import?source=feed", "application/x-ndjson", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v1/import/runs?limit=1")
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
-	defer resp.Body.Close()
+	var runs struct {
+		Runs []importer.Run `json:"runs"`
+	}
+	json.NewDecoder(resp.Body).Decode(&runs)
+	resp.Body.Close()
+	if len(runs.Runs) != 1 || runs.Runs[0].ID != 2 || runs.Runs[0].Updated != 1 || runs.Runs[0].Source != "feed" || runs.Runs[0].PayloadHash == "" {
+		t.Errorf("unexpected runs: %+v", runs.Runs)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/v1/videos/h1/history")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	var history struct {
+		Changes []importer.Change `json:"changes"`
+	}
+	json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	fields := []string{}
+	for _, c := range history.Changes {
+		fields = append(fields, fmt.Sprintf("%d:%s", c.RunID, c.Field))
+	}
+	if fmt.Sprint(fields) != "[2:tags 2:title 1:tags 1:title 1:source]" {
+		t.Errorf("unexpected history: %v", fields)
+	}
 
+	resp, err = http.Get(ts.URL + "/api/v1/import/runs?offset=-1")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusBadRequest {
 		t.Errorf("expected 400, got %d", resp.StatusCode)
 	}
 }
 
-func TestListVideosWithQueryParams(t *testing.T) {
+func TestImportLegacyJS(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
-	seedHandlerTestData(t, db)
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
-	resp, err := http.Get(ts.URL + "/api/v1/videos?tag=tag1&page=1&per_page=10")
+	tests := []struct {
+		body    string
+		expect  int
+		created int
+	}{
+		{`var movies = [{id: "js1", dir: "d/", jpg: "a.jpg", detail: "p/", title: "JS"}];`, http.StatusOK, 1},
+		{`var movies = [{id: "js2", title: }];`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+"/api/v1/import", "text/javascript", bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result struct {
+			Created int    `json:"created"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect || result.Created != tt.created {
+			t.Errorf("%s: expected %d with %d created, got %d with %+v", tt.body, tt.expect, tt.created, resp.StatusCode, result)
+		}
+		if tt.expect == http.StatusBadRequest && !strings.Contains(result.Error, "convert movies.js") {
+			t.Errorf("expected the conversion error in the report, got %q", result.Error)
+		}
+	}
+}
+
+func TestImportExportCSV(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	input := "ID\tTitle\tDate\tActors\tTags\tformat:720p\tformat:1080p\n" +
+		"c1\tVideo \"One\"\t2024-01-02\tAlice | Bob\tdrama\tc1/720.mp4\t\n" +
+		"c2\tVideo Two\t2024-01-01\t\tdrama|comedy\t\tc2/1080.mp4\n" +
+		"c3\tVideo Three\t2023-12-31\t\tcomedy\t\t\n"
+	resp, err := http.Post(ts.URL+"/api/v1/import", "text/tab-separated-values", strings.NewReader(input))
 	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
-	defer resp.Body.Close()
+	var result importer.Result
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || result.Created != 3 {
+		t.Fatalf("expected 3 created, got %d with %+v", resp.StatusCode, result)
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+	resp, err = http.Get(ts.URL + "/api/v1/export?format=csv&tag=drama&sort=date_asc")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	expected := "id,title,url,date,actors,tags,jpg,pictures_dir,format:1080p,format:720p\n" +
+		"c2,Video Two,,2024-01-01,,comedy|drama,,,c2/1080.mp4,\n" +
+		"c1,\"Video \"\"One\"\"\",,2024-01-02,Alice|Bob,drama,,,,c1/720.mp4\n"
+	if string(body) != expected {
+		t.Errorf("unexpected export:\n%s", body)
 	}
 
-	var result map[string]interface{}
+	// The export imports back without changes
+	resp, err = http.Post(ts.URL+"/api/v1/import?dry_run=true", "text/csv", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	result = importer.Result{}
 	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if result.Unchanged != 2 || result.Created+result.Updated != 0 {
+		t.Errorf("expected 2 unchanged, got %+v", result)
+	}
 
-	if result["total"].(float64) != 1 {
-		t.Errorf("expected 1 video with tag1, got %v", result["total"])
+	resp, err = http.Get(ts.URL + "/api/v1/export?format=xml")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown format, got %d", resp.StatusCode)
 	}
 }
 
-func TestListVideosWithMultipleTags(t *testing.T) {
-	r, db := setupTestRouter(t)
-	defer db.Close()
+func TestExportReimport(t *testing.T) {
+	input := `{"id": "e1", "title": "Video <1>", "date": "2024-01-01", "actors": ["Alice"], "tags": ["drama", "comedy"], "jpg": "e1.jpg", "formats": {"720p": "e1.mp4"}}
+{"id": "e2", "title": "Video 2", "url": "https://example.com/2", "date": "2024-02-01", "pictures_dir": "e2/"}
+{"id": "e3", "title": "Video 3", "date": "2024-03-01"}`
 
-	// Seed data with overlapping tags
-	queries := []string{
-		`INSERT INTO videos (id, title, url, date, jpg, pictures_dir) VALUES
-			('vid1', 'First Video', 'https://example.com/1', '2024-01-15', '/thumb1.jpg', '/pics/vid1/'),
-			('vid2', 'Second Video', 'https://example.com/2', '2024-02-20', '/thumb2.jpg', '/pics/vid2/')`,
-		`INSERT INTO tags (name) VALUES ('tagA'), ('tagB'), ('tagC')`,
-		`INSERT INTO video_tags (video_id, tag_id) VALUES ('vid1', 1), ('vid1', 2), ('vid2', 2), ('vid2', 3)`,
-		`INSERT INTO videos_fts (video_id, title, actors, tags) VALUES
-			('vid1', 'First Video', '', 'tagA,tagB'),
-			('vid2', 'Second Video', '', 'tagB,tagC')`,
+	export := func(ts *httptest.Server, query string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/v1/export?" + query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, resp.StatusCode, body)
+		}
+		return string(body)
 	}
-	for _, q := range queries {
-		if _, err := db.Exec(q); err != nil {
-			t.Fatalf("failed to seed: %v\nquery: %s", err, q)
+	post := func(ts *httptest.Server, path, body string) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", path, resp.StatusCode)
 		}
 	}
 
+	r, db := setupTestRouter(t)
+	defer db.Close()
 	ts := httptest.NewServer(r)
 	defer ts.Close()
+	post(ts, "/api/v1/import?source=feed", input)
+	if _, err := db.Exec("INSERT INTO ratings (video_id, rating) VALUES ('e2', 4)"); err != nil {
+		t.Fatalf("failed to rate: %v", err)
+	}
 
-	// Multiple tags: tagA AND tagB -> only vid1
-	resp, err := http.Get(ts.URL + "/api/v1/videos?tag=tagA&tag=tagB")
-	if err != nil {
+	ndjson := export(ts, "ratings=true&sort=date_asc")
+	expected := `{"id":"e1","title":"Video <1>","url":"","date":"2024-01-01","actors":["Alice"],"tags":["comedy","drama"],"jpg":"e1.jpg","pictures_dir":"","formats":{"720p":"e1.mp4"},"source":"feed","rating":0}
+{"id":"e2","title":"Video 2","url":"https://example.com/2","date":"2024-02-01","actors":[],"tags":[],"jpg":"","pictures_dir":"e2/","formats":{},"source":"feed","rating":4}
+{"id":"e3","title":"Video 3","url":"","date":"2024-03-01","actors":[],"tags":[],"jpg":"","pictures_dir":"","formats":{},"source":"feed","rating":0}
+`
+	if ndjson != expected {
+		t.Errorf("unexpected NDJSON export:\n%s", ndjson)
+	}
+	array := export(ts, "format=json&ratings=true&sort=date_asc")
+	var records []map[string]interface{}
+	if err := json.Unmarshal([]byte(array), &records); err != nil || len(records) != 3 {
+		t.Fatalf("expected a JSON array of 3 records, got %v: %s", err, array)
+	}
+	if filtered := export(ts, "tag=drama"); strings.Count(filtered, "\n") != 1 {
+		t.Errorf("expected the filters of List to apply, got %s", filtered)
+	}
+	if empty := export(ts, "format=json&tag=missing"); empty != "[]\n" {
+		t.Errorf("expected an empty array, got %q", empty)
+	}
+
+	// Both formats reproduce the library in an empty database
+	for _, body := range []string{ndjson, array} {
+		r2, db2 := setupTestRouter(t)
+		ts2 := httptest.NewServer(r2)
+		post(ts2, "/api/v1/import", body)
+		if got := export(ts2, "ratings=true&sort=date_asc"); got != ndjson {
+			t.Errorf("expected the re-import to export the same, got:\n%s", got)
+		}
+		ts2.Close()
+		db2.Close()
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v1/export?format=csv&ratings=true")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for ratings in CSV, got %d", resp.StatusCode)
+	}
+}
+
+func TestExportErrorBeforeFirstRecord(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+	// Loading the relations of the first page fails
+	if _, err := db.Exec("DROP TABLE video_tags"); err != nil {
+		t.Fatalf("failed to drop: %v", err)
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, format := range []string{"ndjson", "json", "csv"} {
+		resp, err := http.Get(ts.URL + "/api/v1/export?format=" + format)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s: expected 500, got %d", format, resp.StatusCode)
+		}
+		if d := resp.Header.Get("Content-Disposition"); d != "" {
+			t.Errorf("%s: expected no attachment, got %q", format, d)
+		}
+	}
+}
+
+func TestGetPictures_NotFound(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos/nonexistent/pictures")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetPictures_EmptyArray(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// vid1 exists but /test-media directory does not, so pictures should be []
+	resp, err := http.Get(ts.URL + "/api/v1/videos/vid1/pictures")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	pictures, ok := result["pictures"].([]interface{})
+	if !ok {
+		t.Fatalf("expected pictures to be an array, got %T (%v)", result["pictures"], result["pictures"])
+	}
+	if len(pictures) != 0 {
+		t.Errorf("expected 0 pictures, got %d", len(pictures))
+	}
+}
+
+func TestGetPictures_WithImages(t *testing.T) {
+	// Create a temporary directory with test images
+	tmpDir := t.TempDir()
+	picsDir := filepath.Join(tmpDir, "pics", "vid1")
+	if err := os.MkdirAll(picsDir, 0755); err != nil {
+		t.Fatalf("failed to create pics dir: %v", err)
+	}
+
+	// Create test image files
+	for _, name := range []string{"img1.jpg", "img2.png", "not_image.txt"} {
+		if err := os.WriteFile(filepath.Join(picsDir, name), []byte("fake"), 0644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+	}
+
+	r, db := setupTestRouterWithMediaRoot(t, tmpDir)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos/vid1/pictures")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	pictures := result["pictures"].([]interface{})
+	if len(pictures) != 2 {
+		t.Fatalf("expected 2 pictures, got %d: %v", len(pictures), pictures)
+	}
+
+	// Verify paths start with /
+	for _, p := range pictures {
+		path := p.(string)
+		if path[0] != '/' {
+			t.Errorf("expected path to start with /, got %s", path)
+		}
+	}
+}
+
+func TestListVideosHasVideoTrue(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=true")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if result["total"].(float64) != 2 {
+		t.Errorf("expected 2 videos with formats, got %v", result["total"])
+	}
+}
+
+func TestListVideosHasVideoFalse(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=false")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if result["total"].(float64) != 3 {
+		t.Errorf("expected 3 videos (all), got %v", result["total"])
+	}
+}
+
+func TestListVideosHasVideoInvalid(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos?has_video=invalid")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestListVideosWithQueryParams(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos?tag=tag1&page=1&per_page=10")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if result["total"].(float64) != 1 {
+		t.Errorf("expected 1 video with tag1, got %v", result["total"])
+	}
+}
+
+func TestListVideosWithMultipleTags(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	// Seed data with overlapping tags
+	queries := []string{
+		`INSERT INTO videos (id, title, url, date, jpg, pictures_dir) VALUES
+			('vid1', 'First Video', 'https://example.com/1', '2024-01-15', '/thumb1.jpg', '/pics/vid1/'),
+			('vid2', 'Second Video', 'https://example.com/2', '2024-02-20', '/thumb2.jpg', '/pics/vid2/')`,
+		`INSERT INTO tags (name) VALUES ('tagA'), ('tagB'), ('tagC')`,
+		`INSERT INTO video_tags (video_id, tag_id) VALUES ('vid1', 1), ('vid1', 2), ('vid2', 2), ('vid2', 3)`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("failed to seed: %v\nquery: %s", err, q)
+		}
+	}
+	if err := database.RebuildFTS(db); err != nil {
+		t.Fatalf("failed to index: %v", err)
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Multiple tags: tagA AND tagB -> only vid1
+	resp, err := http.Get(ts.URL + "/api/v1/videos?tag=tagA&tag=tagB")
+	if err != nil {
 		t.Fatalf("failed: %v", err)
 	}
 	defer resp.Body.Close()
@@ -520,3 +1183,563 @@ func TestListVideosWithMultipleTags(t *testing.T) {
 		t.Errorf("expected 1 video with tagA AND tagB, got %v", result["total"])
 	}
 }
+
+func TestVideoFacets(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos/facets?has_video=true&facets=tag,format")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Total  int                                 `json:"total"`
+		Facets map[string][]map[string]interface{} `json:"facets"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if result.Total != 2 {
+		t.Errorf("expected total 2, got %d", result.Total)
+	}
+	if len(result.Facets) != 2 {
+		t.Errorf("expected tag and format facets, got %v", result.Facets)
+	}
+	if len(result.Facets["tag"]) != 2 {
+		t.Errorf("expected 2 tag counts, got %v", result.Facets["tag"])
+	}
+}
+
+func TestVideoFacetsInvalid(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos/facets?facets=tag,unknown")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestListVideosTagModeAndExclusions(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		query         string
+		expectedTotal float64
+	}{
+		{"tag=tag1&tag=tag2&tag_mode=any", 2},
+		{"tag=tag1&tag=tag2", 0},
+		{"exclude_tag=tag1", 2},
+		{"-tag=tag1&-tag=tag2", 1},
+		{"actor=Actor+A&actor=Actor+B&actor_mode=any&-tag=tag2", 1},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?" + tt.query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.query, resp.StatusCode)
+			continue
+		}
+		if result["total"].(float64) != tt.expectedTotal {
+			t.Errorf("%s: expected total %v, got %v", tt.query, tt.expectedTotal, result["total"])
+		}
+	}
+}
+
+func TestListVideosTagModeInvalid(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, query := range []string{"tag_mode=some", "actor_mode=none", "tag=tag1&exclude_tag=tag1", "actor=A&-actor=A"} {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?" + query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestListVideosCursor(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	var ids []string
+	next := ""
+	for pages := 0; pages < 5; pages++ {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?per_page=2&cursor=" + next)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		if _, ok := result["total"]; ok == (next != "") {
+			t.Errorf("expected total only on the first page, got %v", result["total"])
+		}
+		for _, v := range result["data"].([]interface{}) {
+			ids = append(ids, v.(map[string]interface{})["id"].(string))
+		}
+		cursor, _ := result["next_cursor"].(string)
+		if cursor == "" {
+			break
+		}
+		next = cursor
+	}
+
+	if len(ids) != 3 || ids[0] != "vid3" || ids[1] != "vid2" || ids[2] != "vid1" {
+		t.Errorf("expected vid3, vid2, vid1, got %v", ids)
+	}
+}
+
+func TestListVideosInvalidCursor(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, query := range []string{"cursor=garbage", "total=maybe"} {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?" + query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestListVideosSortValidation(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		query  string
+		expect int
+	}{
+		{"sort=rating_desc", http.StatusOK},
+		{"sort=created_desc", http.StatusOK},
+		{"sort=random&seed=7", http.StatusOK},
+		{"sort=popular", http.StatusBadRequest},
+		{"sort=random&seed=abc", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?" + tt.query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expect, resp.StatusCode)
+		}
+	}
+}
+
+func TestListVideosRandomReturnsSeed(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	list := func(query string) (float64, []interface{}) {
+		resp, err := http.Get(ts.URL + "/api/v1/videos?" + query)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		seed, _ := result["seed"].(float64)
+		return seed, result["data"].([]interface{})
+	}
+
+	seed, first := list("sort=random")
+	if seed == 0 {
+		t.Fatal("expected a generated seed in the response")
+	}
+	_, again := list(fmt.Sprintf("sort=random&seed=%.0f", seed))
+	for i := range first {
+		if first[i].(map[string]interface{})["id"] != again[i].(map[string]interface{})["id"] {
+			t.Fatalf("expected the same order when reusing seed %.0f", seed)
+		}
+	}
+}
+
+func TestUpdateVideo(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/videos/vid1")
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	var current struct {
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	json.NewDecoder(resp.Body).Decode(&current)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag on GET")
+	}
+	// The ETag and updated_at in the body name the same version
+	if expected := fmt.Sprintf(`"%d"`, current.UpdatedAt.UnixMilli()); etag != expected {
+		t.Errorf("expected ETag %s from updated_at, got %s", expected, etag)
+	}
+
+	patch := func(body, ifMatch string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/api/v1/videos/vid1", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		return resp
+	}
+
+	resp = patch(`{"title": "Renamed", "add_tags": ["tag2"]}`, etag)
+	var video map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&video)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if video["title"] != "Renamed" {
+		t.Errorf("expected title Renamed, got %v", video["title"])
+	}
+	if tags := video["tags"].([]interface{}); len(tags) != 2 {
+		t.Errorf("expected 2 tags, got %v", tags)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("expected a new ETag after the update")
+	}
+
+	// The old ETag is now stale
+	resp = patch(`{"title": "Again"}`, etag)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409, got %d", resp.StatusCode)
+	}
+
+	// So is an old updated_at in the body
+	resp = patch(`{"title": "Again", "updated_at": "2000-01-01T00:00:00Z"}`, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409, got %d", resp.StatusCode)
+	}
+
+	// The current updated_at from the body is accepted
+	json.NewDecoder(patch(`{"title": "Again"}`, "*").Body).Decode(&current)
+	data, _ := json.Marshal(current.UpdatedAt)
+	resp = patch(`{"title": "Once more", "updated_at": `+string(data)+`}`, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for the current updated_at, got %d", resp.StatusCode)
+	}
+
+	// A version is required
+	resp = patch(`{"title": "Unconditional"}`, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("expected 428, got %d", resp.StatusCode)
+	}
+}
+
+func TestUpdateVideoInvalid(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		body   string
+		expect int
+	}{
+		{"/api/v1/videos/vid1", `not json`, http.StatusBadRequest},
+		{"/api/v1/videos/vid1", `{"title": " "}`, http.StatusBadRequest},
+		{"/api/v1/videos/vid1", `{"date": "2024/01/01"}`, http.StatusBadRequest},
+		{"/api/v1/videos/vid1", `{"tags": ["a"], "add_tags": ["b"]}`, http.StatusBadRequest},
+		{"/api/v1/videos/nonexistent", `{"title": "x"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+tt.path, bytes.NewBufferString(tt.body))
+		req.Header.Set("If-Match", "*")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s %s: expected %d, got %d", tt.path, tt.body, tt.expect, resp.StatusCode)
+		}
+	}
+}
+
+func TestDeleteVideo(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	del := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := del("/api/v1/videos/vid1")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+	resp = del("/api/v1/videos/vid1")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted video, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Post(ts.URL+"/api/v1/prune", "application/json", nil)
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	var pruned map[string]int
+	json.NewDecoder(resp.Body).Decode(&pruned)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if _, ok := pruned["actors"]; !ok {
+		t.Errorf("expected prune counts, got %v", pruned)
+	}
+}
+
+func TestDeleteVideosBulk(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		query   string
+		expect  int
+		deleted int
+	}{
+		{"", http.StatusBadRequest, 0},
+		{"?sort=title_asc", http.StatusBadRequest, 0},
+		{"?id=vid1&tag=tag1", http.StatusBadRequest, 0},
+		{"?id=vid1&id=missing", http.StatusOK, 1},
+		{"?tag=tag2", http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/videos"+tt.query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		var result map[string]int
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("query '%s': expected %d, got %d", tt.query, tt.expect, resp.StatusCode)
+			continue
+		}
+		if tt.expect == http.StatusOK && result["deleted"] != tt.deleted {
+			t.Errorf("query '%s': expected %d deleted, got %d", tt.query, tt.deleted, result["deleted"])
+		}
+	}
+}
+
+func TestTagManagement(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path, body string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		expect int
+	}{
+		{http.MethodPatch, "/api/v1/tags/1", `{"name": "tag2"}`, http.StatusConflict},
+		{http.MethodPatch, "/api/v1/tags/1", `{"name": " "}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/tags/99", `{"name": "x"}`, http.StatusNotFound},
+		{http.MethodPatch, "/api/v1/tags/abc", `{"name": "x"}`, http.StatusNotFound},
+		{http.MethodPatch, "/api/v1/tags/1", `{"name": "Tag One"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/tags/1/aliases", `{"name": "tag2"}`, http.StatusConflict},
+		{http.MethodPost, "/api/v1/tags/1/aliases", `{"name": "タグ１"}`, http.StatusNoContent},
+		{http.MethodPost, "/api/v1/tags/1/merge", `{"tag_ids": []}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tags/1/merge", `{"tag_ids": [99]}`, http.StatusNotFound},
+		{http.MethodPost, "/api/v1/tags/1/merge", `{"tag_ids": [2]}`, http.StatusOK},
+		{http.MethodDelete, "/api/v1/tags/1/aliases/missing", ``, http.StatusNotFound},
+		{http.MethodDelete, "/api/v1/tags/1/aliases/tag2", ``, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		resp, _ := do(tt.method, tt.path, tt.body)
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.body, tt.expect, resp.StatusCode)
+		}
+	}
+
+	resp, result := do(http.MethodGet, "/api/v1/tags/1/aliases", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if fmt.Sprint(result["aliases"]) != "[tag1 タグ１]" {
+		t.Errorf("expected aliases [tag1 タグ１], got %v", result["aliases"])
+	}
+
+	// Both videos now carry the merged tag under its new name
+	_, result = do(http.MethodGet, "/api/v1/videos?tag=Tag+One", "")
+	if result["total"] != float64(2) {
+		t.Errorf("expected 2 videos tagged 'Tag One', got %v", result["total"])
+	}
+}
+
+func TestActorProfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "actors"), 0o755)
+	os.WriteFile(filepath.Join(tmpDir, "actors", "a.jpg"), []byte("fake"), 0o644)
+
+	r, db := setupTestRouterWithMediaRoot(t, tmpDir)
+	defer db.Close()
+	seedHandlerTestData(t, db)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path, body string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		expect int
+	}{
+		{http.MethodGet, "/api/v1/actors/99", ``, http.StatusNotFound},
+		{http.MethodPatch, "/api/v1/actors/1", `{"name": ""}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/actors/1", `{"name": "Actor B"}`, http.StatusConflict},
+		{http.MethodPatch, "/api/v1/actors/1", `{"image_path": "../secret.jpg"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/actors/1", `{"image_path": "/actors/missing.jpg"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/actors/1", `{"name": "女優A", "kana": "じょゆうえー", "image_path": "/actors/a.jpg"}`, http.StatusOK},
+		{http.MethodPost, "/api/v1/actors/1/aliases", `{"name": "Actor B"}`, http.StatusConflict},
+		{http.MethodPost, "/api/v1/actors/1/merge", `{"actor_ids": [2]}`, http.StatusOK},
+		{http.MethodDelete, "/api/v1/actors/1/aliases/missing", ``, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, _ := do(tt.method, tt.path, tt.body)
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.body, tt.expect, resp.StatusCode)
+		}
+	}
+
+	resp, actor := do(http.MethodGet, "/api/v1/actors/1", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if actor["name"] != "女優A" || actor["kana"] != "じょゆうえー" || actor["image_path"] != "/actors/a.jpg" {
+		t.Errorf("unexpected profile: %v", actor)
+	}
+	if fmt.Sprint(actor["aliases"]) != "[Actor A Actor B]" {
+		t.Errorf("expected aliases [Actor A Actor B], got %v", actor["aliases"])
+	}
+	stats := actor["stats"].(map[string]interface{})
+	if stats["video_count"] != float64(2) || stats["first_date"] != "2024-01-15" || stats["last_date"] != "2024-02-20" {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
@@ -1,32 +1,261 @@
 package handler
 
 import (
-	"io"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/iwaco/movies/internal/importer"
 )
 
 type ImportHandler struct {
-	imp *importer.Importer
+	imp              *importer.Importer
+	jobs             *importer.Jobs
+	batchSize        int
+	maxRemovePercent int
 }
 
-func NewImportHandler(imp *importer.Importer) *ImportHandler {
-	return &ImportHandler{imp: imp}
+func NewImportHandler(imp *importer.Importer, jobs *importer.Jobs, batchSize, maxRemovePercent int) *ImportHandler {
+	return &ImportHandler{imp: imp, jobs: jobs, batchSize: batchSize, maxRemovePercent: maxRemovePercent}
 }
 
+// importResponse is the body of every import response, including failed
+// ones, so that callers learn how far an import got.
+type importResponse struct {
+	importer.Result
+	Error string `json:"error,omitempty"`
+}
+
+// Import streams videos into the database, committing every batch_size
+// records. The input is a JSON array, NDJSON (Content-Type
+// application/x-ndjson or format=ndjson), the legacy movies.js
+// (Content-Type text/javascript or format=js), or a CSV or TSV table with
+// a header line (Content-Type text/csv or text/tab-separated-values, or
+// format=csv or tsv). Invalid records are reported and skipped. With
+// strict=true they fail the whole import with 422 instead. With
+// dry_run=true nothing is written and the response lists the changes the
+// import would make.
+//
+// mode=sync also removes the videos missing from the input, soft-deleting
+// them unless delete=hard. The removal is limited to IDs starting with
+// prefix and, when given, videos of the import's source, and is refused
+// with 409 when more than max_remove percent of them would go, unless
+// force=true.
 func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
-	data, err := io.ReadAll(r.Body)
+	opts, err := h.parseOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batches := 0
+	opts.Progress = func(p importer.Result) {
+		if p.Batches != batches {
+			batches = p.Batches
+			log.Printf("import: %d records committed in %d batches", p.Imported, p.Batches)
+		}
+	}
+
+	// A client that disconnects cancels the import rather than leaving
+	// its input truncated
+	result, err := h.imp.ImportStreamContext(r.Context(), r.Body, opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, importer.ErrMalformed):
+			status = http.StatusBadRequest
+		case errors.Is(err, importer.ErrSyncThreshold):
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, importResponse{Result: result, Error: err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if opts.Strict && result.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, importResponse{Result: result})
+}
+
+// StartJob accepts the same input and parameters as Import and runs the
+// import in the background, responding 202 with the queued job.
+func (h *ImportHandler) StartJob(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.parseOptions(r)
 	if err != nil {
-		http.Error(w, "failed to read body", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	job, err := h.jobs.Start(r.Body, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/import/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
 
-	count, err := h.imp.Import(data)
+// ListJobs returns the most recent import jobs, at most limit (default 20).
+func (h *ImportHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	jobs, err := h.jobs.List(limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{"imported": count})
+func (h *ImportHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "import job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// CancelJob asks a queued or running job to stop, responding 202 with the
+// job, or 409 when it has already finished.
+func (h *ImportHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Cancel(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "import job not found", http.StatusNotFound)
+	case errors.Is(err, importer.ErrJobFinished):
+		writeJSON(w, http.StatusConflict, job)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// ListRuns returns the recorded imports, the latest first, paged with limit
+// (default 20) and offset.
+func (h *ImportHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 20, 0
+	for _, p := range []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"limit", &limit, 1},
+		{"offset", &offset, 0},
+	} {
+		if raw := r.URL.Query().Get(p.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < p.min {
+				http.Error(w, fmt.Sprintf("invalid %s parameter", p.name), http.StatusBadRequest)
+				return
+			}
+			*p.value = n
+		}
+	}
+	runs, err := h.imp.Runs(limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"runs": runs})
+}
+
+// VideoHistory returns the changes imports made to a video, the latest
+// first. The history of a deleted video is still returned.
+func (h *ImportHandler) VideoHistory(w http.ResponseWriter, r *http.Request) {
+	changes, err := h.imp.History(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"changes": changes})
+}
+
+func (h *ImportHandler) parseOptions(r *http.Request) (importer.Options, error) {
+	q := r.URL.Query()
+	opts := importer.Options{BatchSize: h.batchSize, Source: q.Get("source")}
+	switch format := q.Get("format"); format {
+	case "":
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/x-ndjson":
+			opts.Format = importer.FormatNDJSON
+		case "text/javascript", "application/javascript":
+			opts.Format = importer.FormatJS
+		case "text/csv":
+			opts.Format = importer.FormatCSV
+		case "text/tab-separated-values":
+			opts.Format = importer.FormatTSV
+		}
+	case importer.FormatJSON, importer.FormatNDJSON, importer.FormatJS, importer.FormatCSV, importer.FormatTSV:
+		opts.Format = format
+	default:
+		return opts, errors.New("invalid format parameter")
+	}
+	if raw := q.Get("batch_size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return opts, errors.New("invalid batch_size parameter")
+		}
+		opts.BatchSize = n
+	}
+
+	sync := importer.SyncOptions{Prefix: q.Get("prefix"), MaxRemovePercent: h.maxRemovePercent}
+	flags := []struct {
+		name  string
+		value *bool
+	}{
+		{"strict", &opts.Strict},
+		{"dry_run", &opts.DryRun},
+		{"force", &sync.Force},
+	}
+	for _, f := range flags {
+		switch q.Get(f.name) {
+		case "", "false":
+		case "true":
+			*f.value = true
+		default:
+			return opts, fmt.Errorf("invalid %s parameter", f.name)
+		}
+	}
+
+	switch q.Get("mode") {
+	case "", "upsert":
+		return opts, nil
+	case "sync":
+	default:
+		return opts, errors.New("invalid mode parameter")
+	}
+	switch q.Get("delete") {
+	case "", "soft":
+	case "hard":
+		sync.Hard = true
+	default:
+		return opts, errors.New("invalid delete parameter")
+	}
+	if raw := q.Get("max_remove"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > 100 {
+			return opts, errors.New("invalid max_remove parameter")
+		}
+		sync.MaxRemovePercent = n
+	}
+	opts.Sync = &sync
+	return opts, nil
 }
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/iwaco/movies/internal/repository"
+)
+
+type TagHandler struct {
+	repo *repository.TagRepository
+}
+
+func NewTagHandler(repo *repository.TagRepository) *TagHandler {
+	return &TagHandler{repo: repo}
+}
+
+// urlParamID reads a numeric ID from the URL, writing a 404 when it is not
+// a number.
+func urlParamID(w http.ResponseWriter, r *http.Request, key string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, key))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeNameError maps the errors of renames, merges and alias changes to
+// responses.
+func writeNameError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrNameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// decodeName reads a {"name": ...} body, writing a 400 when the name is
+// missing or blank.
+func decodeName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return "", false
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// Rename renames a tag. The old name becomes an alias so that later imports
+// still use the renamed tag.
+func (h *TagHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	name, ok := decodeName(w, r)
+	if !ok {
+		return
+	}
+	tag, err := h.repo.Rename(id, name)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// Merge merges the tags listed in tag_ids into the tag in the URL.
+func (h *TagHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		TagIDs []int `json:"tag_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.TagIDs) == 0 {
+		http.Error(w, "tag_ids must not be empty", http.StatusBadRequest)
+		return
+	}
+	tag, err := h.repo.Merge(id, req.TagIDs)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func (h *TagHandler) ListAliases(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	aliases, err := h.repo.Aliases(id)
+	if err != nil {
+		writeNameError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"aliases": aliases})
+}
+
+func (h *TagHandler) AddAlias(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	name, ok := decodeName(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.AddAlias(id, name); err != nil {
+		writeNameError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TagHandler) RemoveAlias(w http.ResponseWriter, r *http.Request) {
+	id, ok := urlParamID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.repo.RemoveAlias(id, chi.URLParam(r, "alias")); err != nil {
+		writeNameError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,12 +1,18 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/iwaco/movies/internal/model"
@@ -22,7 +28,9 @@ func NewVideoHandler(repo *repository.VideoRepository, mediaRoot string) *VideoH
 	return &VideoHandler{repo: repo, mediaRoot: mediaRoot}
 }
 
-func (h *VideoHandler) List(w http.ResponseWriter, r *http.Request) {
+// parseVideoQueryParams reads the filter, sort and paging parameters shared
+// by the video list endpoints.
+func parseVideoQueryParams(r *http.Request) (model.VideoQueryParams, error) {
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	if page < 1 {
 		page = 1
@@ -35,6 +43,22 @@ func (h *VideoHandler) List(w http.ResponseWriter, r *http.Request) {
 	if sort == "" {
 		sort = "date_desc"
 	}
+	if !slices.Contains(model.VideoSorts, sort) {
+		return model.VideoQueryParams{}, errors.New("invalid sort parameter")
+	}
+
+	// A random sort without a seed gets a fresh one, returned in the result
+	// so that the client can request further pages in the same order. It is
+	// kept below 2^53 to survive JSON numbers in JavaScript.
+	var seed int64
+	if raw := r.URL.Query().Get("seed"); raw != "" {
+		var err error
+		if seed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return model.VideoQueryParams{}, errors.New("invalid seed parameter")
+		}
+	} else if sort == "random" {
+		seed = rand.Int64N(1 << 53)
+	}
 
 	var hasVideo bool
 	if raw := r.URL.Query().Get("has_video"); raw != "" {
@@ -44,8 +68,7 @@ func (h *VideoHandler) List(w http.ResponseWriter, r *http.Request) {
 		case "false":
 			hasVideo = false
 		default:
-			http.Error(w, "invalid has_video parameter", http.StatusBadRequest)
-			return
+			return model.VideoQueryParams{}, errors.New("invalid has_video parameter")
 		}
 	}
 
@@ -54,20 +77,115 @@ func (h *VideoHandler) List(w http.ResponseWriter, r *http.Request) {
 		minRating, _ = strconv.Atoi(raw)
 	}
 
-	params := model.VideoQueryParams{
-		Page:      page,
-		PerPage:   perPage,
-		Query:     r.URL.Query().Get("q"),
-		Tags:      r.URL.Query()["tag"],
-		Actors:    r.URL.Query()["actor"],
-		DateFrom:  r.URL.Query().Get("date_from"),
-		DateTo:    r.URL.Query().Get("date_to"),
-		Sort:      sort,
-		MinRating: minRating,
-		HasVideo:  hasVideo,
+	tagMode := r.URL.Query().Get("tag_mode")
+	if tagMode != "" && tagMode != "all" && tagMode != "any" {
+		return model.VideoQueryParams{}, errors.New("invalid tag_mode parameter")
+	}
+	actorMode := r.URL.Query().Get("actor_mode")
+	if actorMode != "" && actorMode != "all" && actorMode != "any" {
+		return model.VideoQueryParams{}, errors.New("invalid actor_mode parameter")
+	}
+
+	// The total is counted by default, except when following a cursor where
+	// it has to be asked for with total=true.
+	cursor := r.URL.Query().Get("cursor")
+	skipTotal := cursor != ""
+	if raw := r.URL.Query().Get("total"); raw != "" {
+		switch raw {
+		case "true":
+			skipTotal = false
+		case "false":
+			skipTotal = true
+		default:
+			return model.VideoQueryParams{}, errors.New("invalid total parameter")
+		}
+	}
+
+	// Exclusions can be given as exclude_tag=x or -tag=x
+	tags := r.URL.Query()["tag"]
+	excludeTags := append(r.URL.Query()["exclude_tag"], r.URL.Query()["-tag"]...)
+	if overlaps(tags, excludeTags) {
+		return model.VideoQueryParams{}, errors.New("tag cannot be both included and excluded")
+	}
+	actors := r.URL.Query()["actor"]
+	excludeActors := append(r.URL.Query()["exclude_actor"], r.URL.Query()["-actor"]...)
+	if overlaps(actors, excludeActors) {
+		return model.VideoQueryParams{}, errors.New("actor cannot be both included and excluded")
+	}
+
+	return model.VideoQueryParams{
+		Page:          page,
+		PerPage:       perPage,
+		Query:         r.URL.Query().Get("q"),
+		Tags:          tags,
+		TagMode:       tagMode,
+		ExcludeTags:   excludeTags,
+		Actors:        actors,
+		ActorMode:     actorMode,
+		ExcludeActors: excludeActors,
+		DateFrom:      r.URL.Query().Get("date_from"),
+		DateTo:        r.URL.Query().Get("date_to"),
+		Sort:          sort,
+		Seed:          seed,
+		MinRating:     minRating,
+		HasVideo:      hasVideo,
+		Cursor:        cursor,
+		SkipTotal:     skipTotal,
+	}, nil
+}
+
+func overlaps(a, b []string) bool {
+	for _, s := range a {
+		if slices.Contains(b, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *VideoHandler) List(w http.ResponseWriter, r *http.Request) {
+	params, err := parseVideoQueryParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	result, err := h.repo.List(params)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Facets returns per-value counts of the videos matching the same filters
+// as List. The optional facets parameter is a comma-separated subset of
+// model.FacetNames.
+func (h *VideoHandler) Facets(w http.ResponseWriter, r *http.Request) {
+	params, err := parseVideoQueryParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var names []string
+	if raw := r.URL.Query().Get("facets"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(model.FacetNames, name) {
+				http.Error(w, "invalid facets parameter", http.StatusBadRequest)
+				return
+			}
+			names = append(names, name)
+		}
+	}
+
+	result, err := h.repo.Facets(params, names)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -83,9 +201,139 @@ func (h *VideoHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	w.Header().Set("ETag", videoETag(video))
+	writeJSON(w, http.StatusOK, video)
+}
+
+// Update applies a partial update. The version the client edited is given
+// either as an If-Match header with the ETag from GetByID or as updated_at
+// in the body; a stale version is rejected with 409, and a request with
+// neither with 428. If-Match: * updates whatever the current version is.
+func (h *VideoHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req model.VideoUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Title != nil && strings.TrimSpace(*req.Title) == "" {
+		http.Error(w, "title must not be empty", http.StatusBadRequest)
+		return
+	}
+	if req.Date != nil && *req.Date != "" {
+		if _, err := time.Parse("2006-01-02", *req.Date); err != nil {
+			http.Error(w, "date must be in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Actors != nil && (len(req.AddActors) > 0 || len(req.RemoveActors) > 0) {
+		http.Error(w, "actors cannot be combined with add_actors or remove_actors", http.StatusBadRequest)
+		return
+	}
+	if req.Tags != nil && (len(req.AddTags) > 0 || len(req.RemoveTags) > 0) {
+		http.Error(w, "tags cannot be combined with add_tags or remove_tags", http.StatusBadRequest)
+		return
+	}
+	match := r.Header.Get("If-Match")
+	if match == "" && req.UpdatedAt == nil {
+		http.Error(w, "If-Match or updated_at is required", http.StatusPreconditionRequired)
+		return
+	}
+	if match != "" && match != "*" {
+		updatedAt, ok := parseVideoETag(match)
+		if !ok {
+			http.Error(w, "video was modified concurrently", http.StatusConflict)
+			return
+		}
+		req.UpdatedAt = &updatedAt
+	}
+
+	video, err := h.repo.Update(id, req)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
 	writeJSON(w, http.StatusOK, video)
 }
 
+// videoETag derives a video's ETag from its updated_at, in milliseconds
+// as it is stored.
+func videoETag(v *model.Video) string {
+	return fmt.Sprintf(`"%d"`, v.UpdatedAt.UnixMilli())
+}
+
+func parseVideoETag(etag string) (time.Time, bool) {
+	millis, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(etag, "W/"), `"`), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
+
+func (h *VideoHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := h.repo.Delete(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMany deletes the videos listed by id=... or, without IDs, every
+// video matching the list filters. Deleting with neither is refused so that
+// a bare DELETE cannot empty the library.
+func (h *VideoHandler) DeleteMany(w http.ResponseWriter, r *http.Request) {
+	params, err := parseVideoQueryParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := r.URL.Query()["id"]
+	if len(ids) > 0 && params.Filtered() {
+		http.Error(w, "id cannot be combined with filters", http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 && !params.Filtered() {
+		http.Error(w, "id or a filter is required", http.StatusBadRequest)
+		return
+	}
+
+	var deleted int
+	if len(ids) > 0 {
+		deleted, err = h.repo.DeleteMany(ids)
+	} else {
+		deleted, err = h.repo.DeleteMatching(params)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": deleted})
+}
+
+func (h *VideoHandler) Prune(w http.ResponseWriter, r *http.Request) {
+	result, err := h.repo.Prune()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
 func (h *VideoHandler) GetPictures(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	video, err := h.repo.GetByID(id)
@@ -112,28 +360,67 @@ func (h *VideoHandler) GetPictures(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]interface{}{"pictures": pictures})
 }
 
+// parseNameQueryParams reads the parameters of the tag and actor lists.
+func parseNameQueryParams(r *http.Request) (model.NameQueryParams, error) {
+	q := r.URL.Query()
+	params := model.NameQueryParams{Query: q.Get("q"), Sort: q.Get("sort")}
+	if params.Sort == "" {
+		params.Sort = "name"
+	}
+	if !slices.Contains(model.NameSorts, params.Sort) {
+		return params, errors.New("invalid sort parameter")
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{
+		{"limit", &params.Limit},
+		{"offset", &params.Offset},
+	} {
+		if raw := q.Get(p.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return params, fmt.Errorf("invalid %s parameter", p.name)
+			}
+			*p.value = n
+		}
+	}
+	switch q.Get("hide_empty") {
+	case "", "false":
+	case "true":
+		params.HideEmpty = true
+	default:
+		return params, errors.New("invalid hide_empty parameter")
+	}
+	return params, nil
+}
+
 func (h *VideoHandler) ListTags(w http.ResponseWriter, r *http.Request) {
-	tags, err := h.repo.ListTags()
+	params, err := parseNameQueryParams(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if tags == nil {
-		tags = []model.Tag{}
+	tags, err := h.repo.ListTags(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{"tags": tags})
+	writeJSON(w, http.StatusOK, tags)
 }
 
 func (h *VideoHandler) ListActors(w http.ResponseWriter, r *http.Request) {
-	actors, err := h.repo.ListActors()
+	params, err := parseNameQueryParams(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if actors == nil {
-		actors = []model.Actor{}
+	actors, err := h.repo.ListActors(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{"actors": actors})
+	writeJSON(w, http.StatusOK, actors)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
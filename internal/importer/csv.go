@@ -0,0 +1,213 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/iwaco/movies/internal/model"
+)
+
+// csvColumns are the columns of FormatCSV and FormatTSV besides the formats,
+// which are in a format:<name> column each holding the file path. Header
+// names are matched case-insensitively and may come in any order. Only the
+// id column is required, and title too for new videos. A stored video
+// keeps the values of the columns the header leaves out, and its formats
+// when there is no format column. Actors and tags are lists separated by
+// ListSeparator.
+var csvColumns = []string{"id", "title", "url", "date", "actors", "tags", "jpg", "pictures_dir"}
+
+const formatColumnPrefix = "format:"
+
+// ListSeparator separates the actors and tags in a CSV or TSV cell. Names
+// containing it do not survive a round trip.
+const ListSeparator = "|"
+
+// csvDecoder decodes videos from the rows of a CSV or TSV table.
+type csvDecoder struct {
+	r *csv.Reader
+	// columns holds, for each column, its name in csvColumns or the
+	// format:<name> prefix followed by the format name as written.
+	columns []string
+	// keep is the videoJSON.keep of every row.
+	keep []string
+}
+
+func newCSVDecoder(r io.Reader, comma rune) (recordDecoder, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	// Spreadsheets write TSV without quoting
+	cr.LazyQuotes = comma == '\t'
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &csvDecoder{r: cr}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &csvDecoder{r: cr}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.TrimSpace(name)
+		column := strings.ToLower(name)
+		switch {
+		case slices.Contains(csvColumns, column):
+		case strings.HasPrefix(column, formatColumnPrefix) && strings.TrimSpace(name[len(formatColumnPrefix):]) != "":
+			column = formatColumnPrefix + strings.TrimSpace(name[len(formatColumnPrefix):])
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if slices.Contains(d.columns, column) {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		d.columns = append(d.columns, column)
+	}
+	if !slices.Contains(d.columns, "id") {
+		return nil, errors.New("missing id column")
+	}
+	for _, column := range csvColumns {
+		if !slices.Contains(d.columns, column) {
+			d.keep = append(d.keep, column)
+		}
+	}
+	if !slices.ContainsFunc(d.columns, func(c string) bool { return strings.HasPrefix(c, formatColumnPrefix) }) {
+		d.keep = append(d.keep, "formats")
+	}
+	return d, nil
+}
+
+func (d *csvDecoder) next() (videoJSON, error) {
+	var v videoJSON
+	if d.columns == nil {
+		return v, io.EOF
+	}
+	v.keep = d.keep
+	row, err := d.r.Read()
+	if errors.Is(err, csv.ErrFieldCount) {
+		// The row is still read, and its ID decoded when it has one
+		err = &invalidRecordError{err: err}
+	} else if err != nil {
+		return v, err
+	}
+	for i, column := range d.columns {
+		if i >= len(row) {
+			break
+		}
+		// Cells are kept as written, spaces included, so that an export
+		// imports back unchanged
+		value := row[i]
+		switch column {
+		case "id":
+			v.ID = value
+		case "title":
+			v.Title = value
+		case "url":
+			v.URL = value
+		case "date":
+			v.Date = value
+		case "actors":
+			v.Actors = splitList(value)
+		case "tags":
+			v.Tags = splitList(value)
+		case "jpg":
+			v.JPG = value
+		case "pictures_dir":
+			v.PicturesDir = value
+		default:
+			if value == "" {
+				continue
+			}
+			if v.Formats == nil {
+				v.Formats = map[string]string{}
+			}
+			v.Formats[column[len(formatColumnPrefix):]] = value
+		}
+	}
+	return v, err
+}
+
+func splitList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ListSeparator) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sniffCSV returns FormatTSV or FormatCSV when the first line of br is a
+// header with an id column, and FormatJS otherwise.
+func sniffCSV(br *bufio.Reader) string {
+	line, _ := br.Peek(br.Size())
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	header := strings.TrimPrefix(string(line), "\ufeff")
+	format, comma := FormatCSV, ","
+	if strings.Contains(header, "\t") {
+		format, comma = FormatTSV, "\t"
+	}
+	for _, name := range strings.Split(header, comma) {
+		if strings.EqualFold(strings.Trim(name, "\" \r"), "id") {
+			return format
+		}
+	}
+	return FormatJS
+}
+
+// CSVWriter writes videos as rows of FormatCSV, or FormatTSV with a tab
+// separator, that import back into the same videos.
+type CSVWriter struct {
+	w       *csv.Writer
+	formats []string
+}
+
+// NewCSVWriter writes the header, with a column for each of formats, and
+// returns a writer for the rows.
+func NewCSVWriter(w io.Writer, comma rune, formats []string) (*CSVWriter, error) {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := slices.Clone(csvColumns)
+	for _, name := range formats {
+		header = append(header, formatColumnPrefix+name)
+	}
+	return &CSVWriter{w: cw, formats: formats}, cw.Write(header)
+}
+
+// Write writes a video. Every format of the video needs a column.
+func (c *CSVWriter) Write(v model.Video) error {
+	actors := make([]string, len(v.Actors))
+	for i, a := range v.Actors {
+		actors[i] = a.Name
+	}
+	tags := make([]string, len(v.Tags))
+	for i, t := range v.Tags {
+		tags[i] = t.Name
+	}
+	row := []string{v.ID, v.Title, v.URL, v.Date, strings.Join(actors, ListSeparator), strings.Join(tags, ListSeparator), v.JPG, v.PicturesDir}
+	paths := make([]string, len(c.formats))
+	for _, f := range v.Formats {
+		i := slices.Index(c.formats, f.Name)
+		if i < 0 {
+			return fmt.Errorf("video %s: no column for format %q", v.ID, f.Name)
+		}
+		paths[i] = f.FilePath
+	}
+	return c.w.Write(append(row, paths...))
+}
+
+// Flush writes any buffered rows to the underlying writer.
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
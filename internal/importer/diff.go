@@ -0,0 +1,98 @@
+package importer
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// VideoDiff is what importing a record changes about a video.
+type VideoDiff struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Fields maps the changed scalar fields, and formats.<name> for
+	// formats whose path changed, to their old and new values.
+	Fields         map[string]FieldChange `json:"fields,omitzero"`
+	AddedActors    []string               `json:"added_actors,omitzero"`
+	RemovedActors  []string               `json:"removed_actors,omitzero"`
+	AddedTags      []string               `json:"added_tags,omitzero"`
+	RemovedTags    []string               `json:"removed_tags,omitzero"`
+	AddedFormats   []string               `json:"added_formats,omitzero"`
+	RemovedFormats []string               `json:"removed_formats,omitzero"`
+}
+
+type FieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// diffVideos compares two canonical records; old is the zero videoJSON for
+// a video that does not exist yet.
+func diffVideos(old, next videoJSON) VideoDiff {
+	d := VideoDiff{ID: next.ID, Fields: map[string]FieldChange{}}
+	for _, f := range []struct {
+		name     string
+		old, new string
+	}{
+		{"title", old.Title, next.Title},
+		{"url", old.URL, next.URL},
+		{"date", old.Date, next.Date},
+		{"jpg", old.JPG, next.JPG},
+		{"pictures_dir", old.PicturesDir, next.PicturesDir},
+		{"source", old.Source, next.Source},
+	} {
+		if f.old != f.new {
+			d.Fields[f.name] = FieldChange{Old: f.old, New: f.new}
+		}
+	}
+	if next.Rating != nil && ratingOf(old) != *next.Rating {
+		d.Fields["rating"] = FieldChange{Old: ratingString(ratingOf(old)), New: ratingString(*next.Rating)}
+	}
+	d.AddedActors, d.RemovedActors = diffNames(old.Actors, next.Actors)
+	d.AddedTags, d.RemovedTags = diffNames(old.Tags, next.Tags)
+	d.AddedFormats, d.RemovedFormats = diffNames(slices.Sorted(maps.Keys(old.Formats)), slices.Sorted(maps.Keys(next.Formats)))
+	for name, path := range next.Formats {
+		if oldPath, ok := old.Formats[name]; ok && oldPath != path {
+			d.Fields["formats."+name] = FieldChange{Old: oldPath, New: path}
+		}
+	}
+	return d
+}
+
+// ratingOf returns the rating of a record, or 0 when it has none.
+func ratingOf(v videoJSON) int {
+	if v.Rating == nil {
+		return 0
+	}
+	return *v.Rating
+}
+
+// ratingString formats a rating for a FieldChange, with "" for none.
+func ratingString(rating int) string {
+	if rating == 0 {
+		return ""
+	}
+	return strconv.Itoa(rating)
+}
+
+// diffNames returns the names only in next and the names only in old; both
+// inputs are sorted.
+func diffNames(old, next []string) (added, removed []string) {
+	for _, name := range next {
+		if _, found := slices.BinarySearch(old, name); !found {
+			added = append(added, name)
+		}
+	}
+	for _, name := range old {
+		if _, found := slices.BinarySearch(next, name); !found {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// empty reports whether the diff changes nothing.
+func (d VideoDiff) empty() bool {
+	return len(d.Fields) == 0 && len(d.AddedActors) == 0 && len(d.RemovedActors) == 0 &&
+		len(d.AddedTags) == 0 && len(d.RemovedTags) == 0 && len(d.AddedFormats) == 0 && len(d.RemovedFormats) == 0
+}
@@ -0,0 +1,95 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/iwaco/movies/internal/model"
+)
+
+// JSONWriter writes videos as the records of FormatJSON or FormatNDJSON
+// that import back into the same videos.
+type JSONWriter struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	enc     *json.Encoder
+	array   bool
+	ratings bool
+	written int
+}
+
+// NewJSONWriter returns a writer of format, FormatJSON or FormatNDJSON.
+// With ratings the records carry the rating of every video, including 0
+// for none, so that importing them also resets the ratings.
+func NewJSONWriter(w io.Writer, format string, ratings bool) (*JSONWriter, error) {
+	if format != FormatJSON && format != FormatNDJSON {
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	j := &JSONWriter{w: w, array: format == FormatJSON, ratings: ratings}
+	j.enc = json.NewEncoder(&j.buf)
+	j.enc.SetEscapeHTML(false)
+	return j, nil
+}
+
+// Write writes a video.
+func (j *JSONWriter) Write(v model.Video) error {
+	rec := videoJSON{
+		ID:          v.ID,
+		Title:       v.Title,
+		URL:         v.URL,
+		Date:        v.Date,
+		Actors:      []string{},
+		Tags:        []string{},
+		JPG:         v.JPG,
+		PicturesDir: v.PicturesDir,
+		Formats:     map[string]string{},
+		Source:      v.Source,
+	}
+	for _, a := range v.Actors {
+		rec.Actors = append(rec.Actors, a.Name)
+	}
+	for _, t := range v.Tags {
+		rec.Tags = append(rec.Tags, t.Name)
+	}
+	for _, f := range v.Formats {
+		rec.Formats[f.Name] = f.FilePath
+	}
+	if j.ratings {
+		rec.Rating = &v.Rating
+	}
+
+	j.buf.Reset()
+	if j.array {
+		if j.written == 0 {
+			j.buf.WriteString("[\n")
+		} else {
+			j.buf.WriteString(",\n")
+		}
+	}
+	if err := j.enc.Encode(rec); err != nil {
+		return err
+	}
+	data := j.buf.Bytes()
+	if j.array {
+		// The comma of the next record goes before the newline
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	j.written++
+	_, err := j.w.Write(data)
+	return err
+}
+
+// Close ends the JSON array; it does nothing for NDJSON.
+func (j *JSONWriter) Close() error {
+	if !j.array {
+		return nil
+	}
+	end := "\n]\n"
+	if j.written == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
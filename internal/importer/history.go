@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Run is a recorded import: its options, a SHA-256 hash of the payload and
+// the counts of its Result. Dry runs are not recorded.
+type Run struct {
+	ID          int64      `json:"id"`
+	Source      string     `json:"source"`
+	Options     Options    `json:"options"`
+	PayloadHash string     `json:"payload_hash"`
+	Records     int        `json:"records"`
+	Created     int        `json:"created"`
+	Updated     int        `json:"updated"`
+	Unchanged   int        `json:"unchanged"`
+	Skipped     int        `json:"skipped"`
+	Failed      int        `json:"failed"`
+	Removed     int        `json:"removed"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Change is a field of a video changed by an import run. Actors and tags
+// are recorded as JSON arrays, formats as formats.<name>, and removal by a
+// sync import as the field deleted with the value soft or hard. The log is
+// kept after a video is deleted.
+type Change struct {
+	RunID     int64     `json:"run_id"`
+	VideoID   string    `json:"video_id"`
+	Field     string    `json:"field"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func (imp *Importer) startRun(opts Options) (int64, error) {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return 0, err
+	}
+	res, err := imp.db.Exec("INSERT INTO import_runs (source, options) VALUES ($1, $2)", opts.Source, string(data))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (imp *Importer) finishRun(id int64, hash string, result Result, err error) error {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	_, err = imp.db.Exec(`UPDATE import_runs SET payload_hash = $2, records = $3, created = $4, updated = $5, unchanged = $6,
+		skipped = $7, failed = $8, removed = $9, error = $10, finished_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		id, hash, result.Records, result.Created, result.Updated, result.Unchanged,
+		result.Skipped, result.Failed, result.Removed, msg)
+	return err
+}
+
+// recordChanges logs what importing next over old changed, as computed in
+// diff, for the run.
+func recordChanges(tx *sql.Tx, runID int64, old, next videoJSON, diff VideoDiff) error {
+	type change struct{ field, old, new string }
+	var changes []change
+	for _, field := range slices.Sorted(maps.Keys(diff.Fields)) {
+		changes = append(changes, change{field, diff.Fields[field].Old, diff.Fields[field].New})
+	}
+	for _, list := range []struct {
+		field     string
+		old, next []string
+		changed   bool
+	}{
+		{"actors", old.Actors, next.Actors, len(diff.AddedActors)+len(diff.RemovedActors) > 0},
+		{"tags", old.Tags, next.Tags, len(diff.AddedTags)+len(diff.RemovedTags) > 0},
+	} {
+		if !list.changed {
+			continue
+		}
+		oldJSON, err := json.Marshal(nonNil(list.old))
+		if err != nil {
+			return err
+		}
+		newJSON, err := json.Marshal(nonNil(list.next))
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{list.field, string(oldJSON), string(newJSON)})
+	}
+	for _, name := range diff.AddedFormats {
+		changes = append(changes, change{"formats." + name, "", next.Formats[name]})
+	}
+	for _, name := range diff.RemovedFormats {
+		changes = append(changes, change{"formats." + name, old.Formats[name], ""})
+	}
+
+	for _, c := range changes {
+		_, err := tx.Exec("INSERT INTO video_changes (run_id, video_id, field, old_value, new_value) VALUES ($1, $2, $3, $4, $5)",
+			runID, next.ID, c.field, c.old, c.new)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRemovals logs the videos ids removed by a sync import.
+func recordRemovals(tx *sql.Tx, runID int64, ids string, hard bool) error {
+	how := "soft"
+	if hard {
+		how = "hard"
+	}
+	_, err := tx.Exec(`INSERT INTO video_changes (run_id, video_id, field, old_value, new_value)
+		SELECT $1, value, 'deleted', '', $2 FROM json_each($3)`, runID, how, ids)
+	return err
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// Runs returns the most recent import runs first, at most limit of them.
+func (imp *Importer) Runs(limit, offset int) ([]Run, error) {
+	rows, err := imp.db.Query(`SELECT id, source, options, payload_hash, records, created, updated, unchanged, skipped,
+		failed, removed, error, started_at, finished_at
+		FROM import_runs ORDER BY id DESC LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		var options string
+		var finished sql.NullTime
+		err := rows.Scan(&run.ID, &run.Source, &options, &run.PayloadHash, &run.Records, &run.Created, &run.Updated,
+			&run.Unchanged, &run.Skipped, &run.Failed, &run.Removed, &run.Error, &run.StartedAt, &finished)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(options), &run.Options); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			run.FinishedAt = &finished.Time
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// History returns the changes imports made to a video, the latest first.
+func (imp *Importer) History(videoID string) ([]Change, error) {
+	rows, err := imp.db.Query(`SELECT run_id, video_id, field, old_value, new_value, changed_at
+		FROM video_changes WHERE video_id = $1 ORDER BY id DESC`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.RunID, &c.VideoID, &c.Field, &c.Old, &c.New, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -1,9 +1,20 @@
 package importer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"strings"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/iwaco/movies/internal/converter"
+	"github.com/iwaco/movies/internal/database"
 )
 
 type Importer struct {
@@ -20,96 +31,635 @@ type videoJSON struct {
 	JPG         string            `json:"jpg"`
 	PicturesDir string            `json:"pictures_dir"`
 	Formats     map[string]string `json:"formats"`
+	// Source labels the video, unless Options.Source overrides it. Videos
+	// keep their source when it is empty.
+	Source string `json:"source,omitempty"`
+	// Rating from 1 to 5 replaces the rating of the video, and 0 removes
+	// it. Without one the rating is left as it is.
+	Rating *int `json:"rating,omitempty"`
+	// keep names the csvColumns, and "formats", that the input does not
+	// have, whose stored values are kept.
+	keep []string
+}
+
+// Input formats accepted by ImportStream.
+const (
+	// FormatAuto detects the format from the first byte: [ for FormatJSON,
+	// { for FormatNDJSON, and otherwise FormatCSV or FormatTSV when the
+	// first line is a header with an id column, or else FormatJS.
+	FormatAuto = ""
+	// FormatJSON is a JSON array of videos.
+	FormatJSON = "json"
+	// FormatNDJSON is one JSON video per line.
+	FormatNDJSON = "ndjson"
+	// FormatJS is the legacy movies.js (var movies = [...];), converted
+	// with converter.ConvertFile. It is read whole rather than streamed.
+	FormatJS = "js"
+	// FormatCSV is a table with a header line, described at csvColumns.
+	FormatCSV = "csv"
+	// FormatTSV is FormatCSV separated by tabs.
+	FormatTSV = "tsv"
+)
+
+// ErrMalformed is wrapped by the errors of input that cannot be decoded.
+var ErrMalformed = errors.New("malformed input")
+
+// ErrSyncThreshold is wrapped by the error of a sync import that would
+// remove more videos than SyncOptions.MaxRemovePercent allows. Such an
+// import is rolled back as a whole.
+var ErrSyncThreshold = errors.New("sync would remove too many videos")
+
+// Record statuses in a Result.
+const (
+	StatusCreated   = "created"
+	StatusUpdated   = "updated"
+	StatusUnchanged = "unchanged"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+type Options struct {
+	Format string `json:"format,omitempty"`
+	// BatchSize is the number of records committed per transaction. With
+	// 0 the whole input is imported in one transaction.
+	BatchSize int `json:"batch_size,omitempty"`
+	// Strict rolls back the whole import when any record fails, instead of
+	// importing the valid records. BatchSize is ignored.
+	Strict bool `json:"strict,omitempty"`
+	// DryRun reports what the import would change without writing
+	// anything: a diff of every video it would create or update, and the
+	// stored videos missing from the input. BatchSize is ignored.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Source labels the videos the import writes. Videos keep their source
+	// when it is empty.
+	Source string `json:"source,omitempty"`
+	// Sync, when set, also removes the stored videos missing from the input.
+	// The import is then written in one transaction with the removals,
+	// which is rolled back if the import stops early, and BatchSize is
+	// ignored.
+	Sync *SyncOptions `json:"sync,omitempty"`
+	// Progress, when set, is called with the counts so far after every
+	// record and after the last commit. The slices of the result it gets
+	// are still being written to.
+	Progress func(Result) `json:"-"`
+}
+
+// SyncOptions configure the removal of the stored videos missing from a
+// sync import. Only videos whose ID starts with Prefix are in scope, and
+// only those with the import's Source when it has one.
+type SyncOptions struct {
+	Prefix string `json:"prefix,omitempty"`
+	// Hard deletes the missing videos instead of marking them deleted.
+	Hard bool `json:"hard,omitempty"`
+	// MaxRemovePercent, when positive, refuses to remove more than this
+	// share of the videos in scope unless Force is set.
+	MaxRemovePercent int  `json:"max_remove_percent,omitempty"`
+	Force            bool `json:"force,omitempty"`
+}
+
+// Result reports an import: how many records were decoded and committed,
+// how many of them were created, updated, unchanged, skipped or failed,
+// and why each skipped or failed record was. It is also returned, with the
+// counts so far, when an import stops on malformed input.
+type Result struct {
+	// Records is the number of records decoded.
+	Records int `json:"records"`
+	// Imported is the number of records committed.
+	Imported  int           `json:"imported"`
+	Batches   int           `json:"batches"`
+	Created   int           `json:"created"`
+	Updated   int           `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Skipped   int           `json:"skipped"`
+	Failed    int           `json:"failed"`
+	Issues    []RecordIssue `json:"issues"`
+	// Diffs is only set by a dry run.
+	Diffs []VideoDiff `json:"diffs,omitzero"`
+	// Missing is set by a dry run and a sync import to the stored videos
+	// missing from the input, and Removed to how many of them a sync
+	// import deleted.
+	Missing []string `json:"missing,omitzero"`
+	Removed int      `json:"removed,omitzero"`
+	// RunID identifies the import in the history; dry runs have none.
+	RunID int64 `json:"run_id,omitzero"`
+}
+
+// RecordIssue is a record that was skipped or failed.
+type RecordIssue struct {
+	// Index is the 1-based position of the record in the input.
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (r *Result) count(status string) {
+	switch status {
+	case StatusCreated:
+		r.Created++
+	case StatusUpdated:
+		r.Updated++
+	case StatusUnchanged:
+		r.Unchanged++
+	}
 }
 
 func New(db *sql.DB) *Importer {
 	return &Importer{db: db}
 }
 
+// Import imports a JSON array of videos in a single transaction, which is
+// rolled back if any record fails.
 func (imp *Importer) Import(data []byte) (int, error) {
-	var videos []videoJSON
-	if err := json.Unmarshal(data, &videos); err != nil {
-		return 0, err
+	result, err := imp.ImportStream(bytes.NewReader(data), Options{Format: FormatJSON, Strict: true})
+	if err == nil && result.Failed > 0 {
+		for _, issue := range result.Issues {
+			if issue.Status == StatusFailed {
+				return 0, fmt.Errorf("record %d: %s", issue.Index, issue.Reason)
+			}
+		}
 	}
+	return result.Imported, err
+}
+
+// ImportStream imports videos decoded one at a time from r, so that memory
+// use does not grow with the size of the input. Each record is validated
+// before it is written. Unless opts.Strict is set, invalid records and
+// records that fail to write are reported and the others imported, with
+// each batch of opts.BatchSize records committed in its own transaction.
+func (imp *Importer) ImportStream(r io.Reader, opts Options) (Result, error) {
+	return imp.ImportStreamContext(context.Background(), r, opts)
+}
 
-	tx, err := imp.db.Begin()
+// ImportStreamContext is ImportStream stopping with ctx.Err() when ctx is
+// done. Like on malformed input, the batches committed so far are kept
+// unless opts.Strict or opts.Sync is set.
+//
+// Except for dry runs, every import is recorded as a Run, with the changes
+// it makes to each video.
+func (imp *Importer) ImportStreamContext(ctx context.Context, r io.Reader, opts Options) (Result, error) {
+	if opts.DryRun {
+		return imp.importStream(ctx, r, opts, 0)
+	}
+	runID, err := imp.startRun(opts)
 	if err != nil {
-		return 0, err
+		return Result{Issues: []RecordIssue{}}, err
+	}
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	result, err := imp.importStream(ctx, tee, opts, runID)
+	if err == nil {
+		// Hash the whole payload, including what the decoder left unread
+		io.Copy(io.Discard, tee)
 	}
-	defer tx.Rollback()
+	if ferr := imp.finishRun(runID, hex.EncodeToString(h.Sum(nil)), result, err); ferr != nil && err == nil {
+		err = ferr
+	}
+	return result, err
+}
 
-	for _, v := range videos {
-		// Upsert video
-		_, err := tx.Exec(`INSERT INTO videos (id, title, url, date, jpg, pictures_dir)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			ON CONFLICT(id) DO UPDATE SET title=$2, url=$3, date=$4, jpg=$5, pictures_dir=$6, updated_at=CURRENT_TIMESTAMP`,
-			v.ID, v.Title, v.URL, v.Date, v.JPG, v.PicturesDir)
-		if err != nil {
-			return 0, err
-		}
+func (imp *Importer) importStream(ctx context.Context, r io.Reader, opts Options, runID int64) (Result, error) {
+	result := Result{Issues: []RecordIssue{}, RunID: runID}
+	if opts.DryRun {
+		result.Diffs, result.Missing = []VideoDiff{}, []string{}
+	}
+	dec, err := newRecordDecoder(r, opts.Format)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	// A sync import commits nothing until its removals pass the threshold
+	batchSize := opts.BatchSize
+	if opts.Strict || opts.DryRun || opts.Sync != nil {
+		batchSize = 0
+	}
 
-		// Clean up old relations for this video
-		tx.Exec("DELETE FROM video_actors WHERE video_id = $1", v.ID)
-		tx.Exec("DELETE FROM video_tags WHERE video_id = $1", v.ID)
-		tx.Exec("DELETE FROM video_formats WHERE video_id = $1", v.ID)
-		tx.Exec("DELETE FROM videos_fts WHERE video_id = $1", v.ID)
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	// written holds the records of a strict or sync import until it
+	// commits, so they can be reported as skipped if it is rolled back.
+	var written []RecordIssue
+	pending := 0
+	commit := func() error {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		tx = nil
+		result.Imported += pending
+		if pending > 0 {
+			result.Batches++
+		}
+		pending = 0
+		return nil
+	}
+	progress := func() {
+		if opts.Progress != nil {
+			opts.Progress(result)
+		}
+	}
+	rollbackAll := func(reason string) {
+		if tx != nil {
+			tx.Rollback()
+			tx = nil
+		}
+		pending = 0
+		result.Created, result.Updated, result.Unchanged = 0, 0, 0
+		if result.Diffs != nil {
+			result.Diffs = []VideoDiff{}
+		}
+		for _, w := range written {
+			w.Status, w.Reason = StatusSkipped, reason
+			result.Issues = append(result.Issues, w)
+			result.Skipped++
+		}
+		slices.SortFunc(result.Issues, func(a, b RecordIssue) int { return a.Index - b.Index })
+	}
+	// stop ends an import early: a strict or sync import is rolled back,
+	// others commit the records written so far.
+	stop := func(reason string) error {
+		if opts.Strict || opts.Sync != nil && !opts.DryRun {
+			rollbackAll(reason)
+		} else if tx != nil && !opts.DryRun {
+			return commit()
+		}
+		return nil
+	}
+	fail := func(index int, id string, err error) {
+		result.Failed++
+		result.Issues = append(result.Issues, RecordIssue{Index: index, ID: id, Status: StatusFailed, Reason: err.Error()})
+	}
 
-		// Insert actors
-		var actorNames []string
-		for _, name := range v.Actors {
-			_, err := tx.Exec("INSERT OR IGNORE INTO actors (name) VALUES ($1)", name)
-			if err != nil {
-				return 0, err
+	seen := map[string]int{}
+	// present holds every ID in the input, valid or not, for a dry run or
+	// sync import to find the stored videos missing from it.
+	present := map[string]bool{}
+	findMissing := opts.DryRun || opts.Sync != nil
+	// inScope is the number of videos a sync import may remove, counted
+	// before it writes anything.
+	inScope := 0
+	if opts.Sync != nil {
+		if tx, err = imp.db.Begin(); err != nil {
+			return result, err
+		}
+		if inScope, err = countInScope(tx, opts); err != nil {
+			return result, err
+		}
+	}
+	for {
+		if result.Records > 0 {
+			// The record before, and the batch it completed
+			progress()
+		}
+		if err := ctx.Err(); err != nil {
+			if serr := stop("import cancelled"); serr != nil {
+				return result, serr
 			}
-			var actorID int
-			if err := tx.QueryRow("SELECT id FROM actors WHERE name = $1", name).Scan(&actorID); err != nil {
-				return 0, err
+			return result, err
+		}
+		v, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		var invalid *invalidRecordError
+		if errors.As(err, &invalid) {
+			err = nil
+		}
+		if err != nil && ctx.Err() != nil {
+			// The input was cut off by the cancellation
+			if serr := stop("import cancelled"); serr != nil {
+				return result, serr
 			}
-			_, err = tx.Exec("INSERT OR IGNORE INTO video_actors (video_id, actor_id) VALUES ($1, $2)", v.ID, actorID)
-			if err != nil {
-				return 0, err
+			return result, ctx.Err()
+		}
+		if err != nil {
+			err = fmt.Errorf("record %d: %w: %w", result.Records+1, ErrMalformed, err)
+			if serr := stop("import stopped on malformed input"); serr != nil {
+				return result, serr
 			}
-			actorNames = append(actorNames, name)
+			return result, err
+		}
+		result.Records++
+		index := result.Records
+		if findMissing && v.ID != "" {
+			present[v.ID] = true
+		}
+		if opts.Source != "" {
+			v.Source = opts.Source
+		}
+		if invalid != nil {
+			fail(index, v.ID, invalid.err)
+			continue
 		}
 
-		// Insert tags
-		var tagNames []string
-		for _, name := range v.Tags {
-			_, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES ($1)", name)
-			if err != nil {
-				return 0, err
-			}
-			var tagID int
-			if err := tx.QueryRow("SELECT id FROM tags WHERE name = $1", name).Scan(&tagID); err != nil {
-				return 0, err
+		if err := validate(v, seen); err != nil {
+			fail(index, v.ID, err)
+			continue
+		}
+		seen[v.ID] = index
+		if opts.Strict && result.Failed > 0 {
+			// Nothing will be committed; keep validating the rest
+			written = append(written, RecordIssue{Index: index, ID: v.ID})
+			continue
+		}
+
+		if tx == nil {
+			if tx, err = imp.db.Begin(); err != nil {
+				return result, err
 			}
-			_, err = tx.Exec("INSERT OR IGNORE INTO video_tags (video_id, tag_id) VALUES ($1, $2)", v.ID, tagID)
-			if err != nil {
-				return 0, err
+		}
+		diff, err := importRecord(tx, v, opts.DryRun, runID)
+		if err != nil {
+			fail(index, v.ID, err)
+			continue
+		}
+		result.count(diff.Status)
+		if opts.DryRun && diff.Status != StatusUnchanged {
+			result.Diffs = append(result.Diffs, diff)
+		}
+		if opts.Strict || opts.Sync != nil {
+			written = append(written, RecordIssue{Index: index, ID: v.ID})
+		}
+		pending++
+		if batchSize > 0 && pending >= batchSize {
+			if err := commit(); err != nil {
+				return result, err
 			}
-			tagNames = append(tagNames, name)
 		}
+	}
 
-		// Insert formats
-		for name, filePath := range v.Formats {
-			_, err := tx.Exec("INSERT OR IGNORE INTO video_formats (video_id, name, file_path) VALUES ($1, $2, $3)",
-				v.ID, name, filePath)
-			if err != nil {
-				return 0, err
+	if opts.Strict && result.Failed > 0 {
+		rollbackAll(fmt.Sprintf("strict import rolled back because %d records failed", result.Failed))
+		if !opts.DryRun {
+			return result, nil
+		}
+	}
+	if findMissing {
+		if tx == nil {
+			if tx, err = imp.db.Begin(); err != nil {
+				return result, err
+			}
+		}
+		if err := removeMissing(tx, present, opts, inScope, &result); err != nil {
+			if serr := stop(err.Error()); serr != nil {
+				return result, serr
 			}
+			return result, err
 		}
+		if opts.DryRun {
+			return result, nil
+		}
+	}
+	if tx != nil {
+		if err := commit(); err != nil {
+			return result, err
+		}
+		progress()
+	}
+	return result, nil
+}
+
+// importRecord writes a record inside a savepoint, so that a failure undoes
+// only this record.
+func importRecord(tx *sql.Tx, v videoJSON, dryRun bool, runID int64) (VideoDiff, error) {
+	if _, err := tx.Exec("SAVEPOINT record"); err != nil {
+		return VideoDiff{}, err
+	}
+	diff, err := importVideo(tx, v, dryRun, runID)
+	if err != nil {
+		tx.Exec("ROLLBACK TO record")
+		tx.Exec("RELEASE record")
+		return VideoDiff{}, err
+	}
+	_, err = tx.Exec("RELEASE record")
+	return diff, err
+}
+
+// importVideo upserts a video, logs the changes for runID, and returns what
+// changed, with the status created, updated or unchanged. Nothing but the
+// content hash is written for an unchanged video, and nothing in a dry run.
+func importVideo(tx *sql.Tx, v videoJSON, dryRun bool, runID int64) (VideoDiff, error) {
+	v, err := fillKept(tx, v)
+	if err != nil {
+		return VideoDiff{}, err
+	}
+	next, err := canonical(tx, v)
+	if err != nil {
+		return VideoDiff{}, err
+	}
+	hash, err := contentHash(next)
+	if err != nil {
+		return VideoDiff{}, err
+	}
+	// A video whose stored hash matches is unchanged without comparing
+	// its relations. A mismatch may still be unchanged, for example after
+	// a rename of one of its tags, which the comparison below finds.
+	var storedHash, storedSource string
+	var storedRating int
+	err = tx.QueryRow(`SELECT content_hash, source, COALESCE((SELECT rating FROM ratings WHERE video_id = videos.id), 0)
+		FROM videos WHERE id = $1 AND deleted_at IS NULL`, v.ID).
+		Scan(&storedHash, &storedSource, &storedRating)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return VideoDiff{}, err
+	}
+	if err == nil && storedHash == hash && (next.Source == "" || next.Source == storedSource) &&
+		(next.Rating == nil || *next.Rating == storedRating) {
+		return VideoDiff{ID: v.ID, Status: StatusUnchanged}, nil
+	}
+
+	status := StatusUpdated
+	old, err := loadVideo(tx, v.ID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Also restores a soft-deleted video
+		status = StatusCreated
+	case err != nil:
+		return VideoDiff{}, err
+	}
+	if next.Source == "" {
+		next.Source = old.Source
+	}
+	diff := diffVideos(old, next)
+	diff.Status = status
+	if diff.empty() {
+		diff.Status = StatusUnchanged
+	}
+	if dryRun {
+		return diff, nil
+	}
+	if diff.Status == StatusUnchanged {
+		_, err := tx.Exec("UPDATE videos SET content_hash = $2 WHERE id = $1", v.ID, hash)
+		return diff, err
+	}
 
-		// Update FTS
-		_, err = tx.Exec("INSERT INTO videos_fts (video_id, title, actors, tags) VALUES ($1, $2, $3, $4)",
-			v.ID, v.Title, strings.Join(actorNames, ","), strings.Join(tagNames, ","))
+	// Upsert video
+	_, err = tx.Exec(`INSERT INTO videos (id, title, url, date, jpg, pictures_dir, source, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT(id) DO UPDATE SET title=$2, url=$3, date=$4, jpg=$5, pictures_dir=$6, source=COALESCE(NULLIF($7, ''), source),
+			content_hash=$8, deleted_at=NULL, updated_at=`+database.TouchUpdatedAt,
+		v.ID, v.Title, v.URL, v.Date, v.JPG, v.PicturesDir, next.Source, hash)
+	if err != nil {
+		return VideoDiff{}, err
+	}
+	if v.Rating != nil {
+		if *v.Rating == 0 {
+			_, err = tx.Exec("DELETE FROM ratings WHERE video_id = $1", v.ID)
+		} else {
+			_, err = tx.Exec(`INSERT INTO ratings (video_id, rating) VALUES ($1, $2)
+				ON CONFLICT(video_id) DO UPDATE SET rating = $2, updated_at = CURRENT_TIMESTAMP`, v.ID, *v.Rating)
+		}
 		if err != nil {
-			return 0, err
+			return VideoDiff{}, err
+		}
+	}
+
+	// Clean up old relations for this video
+	tx.Exec("DELETE FROM video_actors WHERE video_id = $1", v.ID)
+	tx.Exec("DELETE FROM video_tags WHERE video_id = $1", v.ID)
+	tx.Exec("DELETE FROM video_formats WHERE video_id = $1", v.ID)
+
+	// Insert actors and tags, resolving aliases
+	for _, name := range v.Actors {
+		actorID, err := database.Actors.Resolve(tx, name)
+		if err != nil {
+			return VideoDiff{}, err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO video_actors (video_id, actor_id) VALUES ($1, $2)", v.ID, actorID)
+		if err != nil {
+			return VideoDiff{}, err
+		}
+	}
+	for _, name := range v.Tags {
+		tagID, err := database.Tags.Resolve(tx, name)
+		if err != nil {
+			return VideoDiff{}, err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO video_tags (video_id, tag_id) VALUES ($1, $2)", v.ID, tagID)
+		if err != nil {
+			return VideoDiff{}, err
+		}
+	}
+
+	// Insert formats
+	for name, filePath := range v.Formats {
+		_, err := tx.Exec("INSERT OR IGNORE INTO video_formats (video_id, name, file_path) VALUES ($1, $2, $3)",
+			v.ID, name, filePath)
+		if err != nil {
+			return VideoDiff{}, err
+		}
+	}
+
+	if err := recordChanges(tx, runID, old, next, diff); err != nil {
+		return VideoDiff{}, err
+	}
+
+	// Update FTS
+	return diff, database.IndexVideo(tx, v.ID)
+}
+
+// invalidRecordError is returned by a recordDecoder for a record it could
+// read past but not decode, which fails only that record. The video holds
+// what could be decoded, such as its ID.
+type invalidRecordError struct {
+	err error
+}
+
+func (e *invalidRecordError) Error() string {
+	return e.err.Error()
+}
+
+// recordDecoder decodes videos one at a time.
+type recordDecoder interface {
+	// next returns the next video, or io.EOF after the last one.
+	next() (videoJSON, error)
+}
+
+func newRecordDecoder(r io.Reader, format string) (recordDecoder, error) {
+	br := bufio.NewReader(r)
+	if format == FormatAuto {
+		format = FormatNDJSON
+		if first, err := peekNonSpace(br); err == nil && first == '[' {
+			format = FormatJSON
+		} else if err == nil && first != '{' {
+			format = sniffCSV(br)
+		}
+	}
+	var in io.Reader = br
+	switch format {
+	case FormatCSV:
+		return newCSVDecoder(br, ',')
+	case FormatTSV:
+		return newCSVDecoder(br, '\t')
+	case FormatJS:
+		input, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
 		}
+		converted, err := converter.ConvertFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("convert movies.js: %w", err)
+		}
+		in, format = bytes.NewReader(converted), FormatJSON
 	}
 
-	if err := tx.Commit(); err != nil {
-		return 0, err
+	d := &jsonDecoder{dec: json.NewDecoder(in)}
+	switch format {
+	case FormatJSON:
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if tok != json.Delim('[') {
+			return nil, errors.New("expected a JSON array of videos")
+		}
+		d.array = true
+	case FormatNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
 	}
+	return d, nil
+}
+
+// jsonDecoder decodes videos from a JSON array or NDJSON.
+type jsonDecoder struct {
+	dec   *json.Decoder
+	array bool
+}
 
-	return len(videos), nil
+func (d *jsonDecoder) next() (videoJSON, error) {
+	var v videoJSON
+	if d.array {
+		if !d.dec.More() {
+			// More is also false at the end of a truncated array
+			if _, err := d.dec.Token(); err == io.EOF {
+				return v, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return v, err
+			}
+			return v, io.EOF
+		}
+	}
+	err := d.dec.Decode(&v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// The decoder has read past the value and decoded the other fields
+		err = &invalidRecordError{err: err}
+	}
+	return v, err
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
 }
@@ -1,6 +1,14 @@
 package importer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/iwaco/movies/internal/database"
@@ -184,3 +192,992 @@ func TestImportIdempotent(t *testing.T) {
 		t.Errorf("expected 1 video after re-import, got %d", videoCount)
 	}
 }
+
+func TestImportNormalizesFTS(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	jsonData := []byte(`[
+		{
+			"id": "abc123",
+			"title": "ﾃｽﾄ動画",
+			"actors": ["出演者Ａ"],
+			"tags": ["たぐ"]
+		}
+	]`)
+
+	imp := New(db)
+	if _, err := imp.Import(jsonData); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	var title, actors, tags string
+	err := db.QueryRow("SELECT title, actors, tags FROM videos_fts WHERE video_id = 'abc123'").Scan(&title, &actors, &tags)
+	if err != nil {
+		t.Fatalf("failed to read fts row: %v", err)
+	}
+	if title != "テスト動画" || actors != "出演者a" || tags != "タグ" {
+		t.Errorf("expected normalized fts row, got title=%s actors=%s tags=%s", title, actors, tags)
+	}
+
+	// The original text is kept as-is in videos
+	var original string
+	db.QueryRow("SELECT title FROM videos WHERE id = 'abc123'").Scan(&original)
+	if original != "ﾃｽﾄ動画" {
+		t.Errorf("expected original title to be kept, got %s", original)
+	}
+}
+
+func TestImportResolvesTagAliases(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO tags (name) VALUES ('tag1')`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO tag_aliases (name, tag_id) VALUES ('Tag1', 1), ('タグ１', 1)`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	jsonData := []byte(`[
+		{"id": "v1", "title": "Video 1", "tags": ["Tag1", "タグ１"]},
+		{"id": "v2", "title": "Video 2", "tags": ["tag1", "new"]}
+	]`)
+
+	imp := New(db)
+	if _, err := imp.Import(jsonData); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	var tagCount int
+	db.QueryRow("SELECT COUNT(*) FROM tags").Scan(&tagCount)
+	if tagCount != 2 {
+		t.Errorf("expected aliases not to create tags, got %d tags", tagCount)
+	}
+	var linked int
+	db.QueryRow("SELECT COUNT(*) FROM video_tags WHERE tag_id = 1").Scan(&linked)
+	if linked != 2 {
+		t.Errorf("expected both videos to be tagged tag1, got %d", linked)
+	}
+}
+
+func TestImportResolvesActorAliases(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO actors (name) VALUES ('山田花子')`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO actor_aliases (name, actor_id) VALUES ('Yamada Hanako', 1)`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	imp := New(db)
+	if _, err := imp.Import([]byte(`[{"id": "v1", "title": "Video 1", "actors": ["Yamada Hanako"]}]`)); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	var actorCount, linked int
+	db.QueryRow("SELECT COUNT(*) FROM actors").Scan(&actorCount)
+	db.QueryRow("SELECT COUNT(*) FROM video_actors WHERE actor_id = 1").Scan(&linked)
+	if actorCount != 1 || linked != 1 {
+		t.Errorf("expected the alias to link the canonical actor, got %d actors and %d links", actorCount, linked)
+	}
+}
+
+func TestImportStream(t *testing.T) {
+	ndjson := `{"id": "v1", "title": "Video 1", "tags": ["a"]}
+{"id": "v2", "title": "Video 2"}
+
+{"id": "v3", "title": "Video 3"}
+{"id": "v4", "title": "Video 4"}
+{"id": "v5", "title": "Video 5", "actors": ["Actor A"]}
+`
+	array := `  [{"id": "v1", "title": "Video 1"}, {"id": "v2", "title": "Video 2"}, {"id": "v3", "title": "Video 3"}]`
+
+	tests := []struct {
+		name     string
+		input    string
+		format   string
+		batch    int
+		expected Result
+	}{
+		{"NDJSON", ndjson, FormatNDJSON, 2, Result{Records: 5, Imported: 5, Batches: 3, Created: 5}},
+		{"NDJSON自動判定", ndjson, FormatAuto, 10, Result{Records: 5, Imported: 5, Batches: 1, Created: 5}},
+		{"配列自動判定", array, FormatAuto, 2, Result{Records: 3, Imported: 3, Batches: 2, Created: 3}},
+		{"一括", array, FormatJSON, 0, Result{Records: 3, Imported: 3, Batches: 1, Created: 3}},
+		{"空", "", FormatNDJSON, 2, Result{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupImporterTestDB(t)
+			defer db.Close()
+
+			var progress []Result
+			result, err := New(db).ImportStream(strings.NewReader(tt.input), Options{
+				Format:    tt.format,
+				BatchSize: tt.batch,
+				Progress:  func(r Result) { progress = append(progress, r) },
+			})
+			if err != nil {
+				t.Fatalf("failed to import: %v", err)
+			}
+			if result.RunID == 0 {
+				t.Error("expected the import to be recorded as a run")
+			}
+			result.Issues, result.RunID = nil, 0
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+			if tt.expected.Records == 0 {
+				if len(progress) != 0 {
+					t.Errorf("expected no progress, got %v", progress)
+				}
+			} else {
+				// Once per record, and once more after the last commit
+				if len(progress) != tt.expected.Records+1 {
+					t.Errorf("expected progress after each of %d records, got %v", tt.expected.Records, progress)
+				}
+				for i, p := range progress[:tt.expected.Records] {
+					if p.Records != i+1 {
+						t.Errorf("progress %d: expected %d records, got %+v", i, i+1, p)
+					}
+				}
+				if last := progress[len(progress)-1]; last.Imported != tt.expected.Imported || last.Batches != tt.expected.Batches {
+					t.Errorf("expected the last progress to have every batch, got %+v", last)
+				}
+			}
+
+			var count int
+			db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+			if count != tt.expected.Imported {
+				t.Errorf("expected %d videos, got %d", tt.expected.Imported, count)
+			}
+		})
+	}
+}
+
+func TestImportStreamMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		format   string
+		expected Result
+	}{
+		// The records before the malformed one are committed
+		{"途中の不正な行", "{\"id\": \"v1\", \"title\": \"1\"}\n{\"id\": \"v2\", \"title\": \"2\"}\n{\"id\": \"v3\", \"title\": \"3\"}\n{oops}\n",
+			FormatNDJSON, Result{Records: 3, Imported: 3, Batches: 2, Created: 3}},
+		{"閉じていない配列", `[{"id": "v1", "title": "1"}`, FormatJSON, Result{Records: 1, Imported: 1, Batches: 1, Created: 1}},
+		{"配列でない", `{"id": "v1", "title": "1"}`, FormatJSON, Result{}},
+		{"不明な形式", `[]`, "xml", Result{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupImporterTestDB(t)
+			defer db.Close()
+
+			result, err := New(db).ImportStream(strings.NewReader(tt.input), Options{Format: tt.format, BatchSize: 2})
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected ErrMalformed, got %v", err)
+			}
+			if result.RunID == 0 {
+				t.Error("expected the import to be recorded as a run")
+			}
+			result.Issues, result.RunID = nil, 0
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+
+			var count int
+			db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+			if count != tt.expected.Imported {
+				t.Errorf("expected %d committed videos, got %d", tt.expected.Imported, count)
+			}
+		})
+	}
+}
+
+func TestImportStreamWrongType(t *testing.T) {
+	inputs := map[string]string{
+		FormatNDJSON: "{\"id\": \"v1\", \"title\": \"1\"}\n{\"id\": \"v2\", \"title\": \"2\", \"date\": 20240101}\n{\"id\": \"v3\", \"title\": \"3\"}\n",
+		FormatJSON:   `[{"id": "v1", "title": "1"}, {"id": "v2", "title": "2", "date": 20240101}, {"id": "v3", "title": "3"}]`,
+	}
+	for format, input := range inputs {
+		t.Run(format, func(t *testing.T) {
+			db := setupImporterTestDB(t)
+			defer db.Close()
+
+			// Only the record with the wrong-typed field fails
+			result, err := New(db).ImportStream(strings.NewReader(input), Options{Format: format})
+			if err != nil {
+				t.Fatalf("failed to import: %v", err)
+			}
+			if result.Records != 3 || result.Created != 2 || result.Failed != 1 {
+				t.Errorf("expected 2 created and 1 failed of 3 records, got %+v", result)
+			}
+			if len(result.Issues) != 1 || result.Issues[0].Index != 2 || result.Issues[0].ID != "v2" {
+				t.Errorf("expected record 2 to fail, got %+v", result.Issues)
+			}
+		})
+	}
+}
+
+func TestImportStreamReport(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	if _, err := imp.Import([]byte(`[
+		{"id": "old1", "title": "Old 1", "tags": ["tag1"]},
+		{"id": "old2", "title": "Old 2", "tags": ["tag1"]}
+	]`)); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	input := `{"id": "new1", "title": "New 1"}
+{"id": "old1", "title": "Old 1", "tags": ["tag1"]}
+{"id": "old2", "title": "Old 2 renamed", "tags": ["tag1"]}
+{"id": "", "title": "No ID"}
+{"id": "bad1", "title": " "}
+{"id": "bad2", "title": "Bad date", "date": "2024/01/01"}
+{"id": "bad3", "title": "Bad path", "jpg": "../../etc/passwd"}
+{"id": "bad4", "title": "Bad format path", "formats": {"720p": "http://example.com/a.mp4"}}
+{"id": "new1", "title": "Duplicate"}
+`
+	result, err := imp.ImportStream(strings.NewReader(input), Options{Format: FormatNDJSON, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Created != 1 || result.Updated != 1 || result.Unchanged != 1 || result.Failed != 6 || result.Imported != 3 {
+		t.Errorf("unexpected counts: %+v", result)
+	}
+
+	expected := []struct {
+		index  int
+		reason string
+	}{
+		{4, "id is required"},
+		{5, "title is required"},
+		{6, "date"},
+		{7, "jpg"},
+		{8, "formats.720p"},
+		{9, "duplicate id, first seen in record 1"},
+	}
+	if len(result.Issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %+v", len(expected), result.Issues)
+	}
+	for i, want := range expected {
+		issue := result.Issues[i]
+		if issue.Index != want.index || issue.Status != StatusFailed || !strings.Contains(issue.Reason, want.reason) {
+			t.Errorf("issue %d: expected record %d failing with %q, got %+v", i, want.index, want.reason, issue)
+		}
+	}
+
+	var title string
+	db.QueryRow("SELECT title FROM videos WHERE id = 'old2'").Scan(&title)
+	if title != "Old 2 renamed" {
+		t.Errorf("expected the valid update to be committed, got %s", title)
+	}
+}
+
+func TestImportStreamStrict(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	input := `[
+		{"id": "v1", "title": "Video 1"},
+		{"id": "v2", "title": ""},
+		{"id": "v3", "title": "Video 3"}
+	]`
+	result, err := New(db).ImportStream(strings.NewReader(input), Options{Strict: true, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Imported != 0 || result.Created != 0 || result.Failed != 1 || result.Skipped != 2 {
+		t.Errorf("unexpected counts: %+v", result)
+	}
+	statuses := []string{}
+	for _, issue := range result.Issues {
+		statuses = append(statuses, fmt.Sprintf("%d:%s", issue.Index, issue.Status))
+	}
+	if fmt.Sprint(statuses) != "[1:skipped 2:failed 3:skipped]" {
+		t.Errorf("unexpected issues: %+v", result.Issues)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the strict import to be rolled back, got %d videos", count)
+	}
+}
+
+func TestImportUnchangedResolvesAliases(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	if _, err := imp.Import([]byte(`[{"id": "v1", "title": "Video 1", "tags": ["tag1", "tag2"], "formats": {"720p": "/a.mp4"}}]`)); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO tag_aliases (name, tag_id) VALUES ('Tag1', 1)`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	// The same video with an alias, reordered and duplicated tags is unchanged
+	result, err := imp.ImportStream(strings.NewReader(`{"id": "v1", "title": "Video 1", "tags": ["tag2", "Tag1", "tag1"], "formats": {"720p": "/a.mp4"}}`), Options{})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Unchanged != 1 || result.Updated != 0 {
+		t.Errorf("expected the record to be unchanged, got %+v", result)
+	}
+}
+
+func TestImportStreamDryRun(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	_, err := imp.Import([]byte(`[
+		{"id": "v1", "title": "Video 1", "date": "2024-01-01", "actors": ["Actor A"], "tags": ["tag1", "tag2"], "formats": {"720p": "/a.mp4", "480p": "/b.mp4"}},
+		{"id": "v2", "title": "Video 2"},
+		{"id": "v4", "title": "Video 4"}
+	]`))
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	input := `{"id": "v1", "title": "Video 1 (HD)", "date": "2024-01-01", "actors": ["Actor A", "Actor B"], "tags": ["tag1"], "formats": {"720p": "/a2.mp4", "1080p": "/c.mp4"}}
+{"id": "v2", "title": "Video 2"}
+{"id": "v3", "title": "Video 3", "tags": ["tag3"]}
+`
+	result, err := imp.ImportStream(strings.NewReader(input), Options{DryRun: true, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("failed to dry run: %v", err)
+	}
+	if result.Imported != 0 || result.Batches != 0 || result.Created != 1 || result.Updated != 1 || result.Unchanged != 1 {
+		t.Errorf("unexpected counts: %+v", result)
+	}
+	expected := []VideoDiff{
+		{
+			ID:     "v1",
+			Status: StatusUpdated,
+			Fields: map[string]FieldChange{
+				"title":        {Old: "Video 1", New: "Video 1 (HD)"},
+				"formats.720p": {Old: "/a.mp4", New: "/a2.mp4"},
+			},
+			AddedActors:    []string{"Actor B"},
+			RemovedTags:    []string{"tag2"},
+			AddedFormats:   []string{"1080p"},
+			RemovedFormats: []string{"480p"},
+		},
+		{
+			ID:        "v3",
+			Status:    StatusCreated,
+			Fields:    map[string]FieldChange{"title": {Old: "", New: "Video 3"}},
+			AddedTags: []string{"tag3"},
+		},
+	}
+	if !reflect.DeepEqual(result.Diffs, expected) {
+		t.Errorf("expected diffs %+v, got %+v", expected, result.Diffs)
+	}
+	if !reflect.DeepEqual(result.Missing, []string{"v4"}) {
+		t.Errorf("expected v4 to be missing, got %v", result.Missing)
+	}
+
+	var title string
+	db.QueryRow("SELECT title FROM videos WHERE id = 'v1'").Scan(&title)
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+	if title != "Video 1" || count != 3 {
+		t.Errorf("expected the dry run to write nothing, got title %q and %d videos", title, count)
+	}
+}
+
+func TestImportStreamSync(t *testing.T) {
+	seed := `[
+		{"id": "a-1", "title": "A1"},
+		{"id": "a-2", "title": "A2"},
+		{"id": "a-3", "title": "A3"},
+		{"id": "b-1", "title": "B1"}
+	]`
+	input := `{"id": "a-1", "title": "A1"}
+{"id": "a-2", "title": "A2"}
+`
+	tests := []struct {
+		name    string
+		source  string
+		sync    SyncOptions
+		err     error
+		missing []string
+		live    int
+		stored  int
+	}{
+		{"ソフト削除", "", SyncOptions{}, nil, []string{"a-3", "b-1"}, 2, 4},
+		{"ハード削除", "", SyncOptions{Hard: true}, nil, []string{"a-3", "b-1"}, 2, 2},
+		{"プレフィックス", "", SyncOptions{Prefix: "a-"}, nil, []string{"a-3"}, 3, 4},
+		{"ソース", "feed", SyncOptions{}, nil, []string{}, 4, 4},
+		{"しきい値", "", SyncOptions{MaxRemovePercent: 25}, ErrSyncThreshold, []string{"a-3", "b-1"}, 4, 4},
+		{"強制", "", SyncOptions{MaxRemovePercent: 25, Force: true}, nil, []string{"a-3", "b-1"}, 2, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupImporterTestDB(t)
+			defer db.Close()
+
+			imp := New(db)
+			if _, err := imp.Import([]byte(seed)); err != nil {
+				t.Fatalf("failed to seed: %v", err)
+			}
+			sync := tt.sync
+			result, err := imp.ImportStream(strings.NewReader(input), Options{Source: tt.source, Sync: &sync})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(result.Missing, tt.missing) {
+				t.Errorf("expected missing %v, got %v", tt.missing, result.Missing)
+			}
+			if tt.err == nil && result.Removed != len(tt.missing) {
+				t.Errorf("expected %d removed, got %d", len(tt.missing), result.Removed)
+			}
+
+			var live, stored int
+			db.QueryRow("SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL").Scan(&live)
+			db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&stored)
+			if live != tt.live || stored != tt.stored {
+				t.Errorf("expected %d live of %d stored videos, got %d of %d", tt.live, tt.stored, live, stored)
+			}
+		})
+	}
+}
+
+func TestImportStreamSyncThresholdRollsBack(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	var seed strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&seed, `{"id": "v%d", "title": "Video %d"}`+"\n", i, i)
+	}
+	if _, err := imp.ImportStream(strings.NewReader(seed.String()), Options{}); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	// 9 of the 10 videos are missing: the new video must not lower the
+	// share to 9 of 11, and the title change must not be kept
+	input := `{"id": "v1", "title": "Changed"}
+{"id": "new", "title": "New"}
+`
+	for _, batchSize := range []int{0, 1} {
+		result, err := imp.ImportStream(strings.NewReader(input), Options{BatchSize: batchSize, Sync: &SyncOptions{MaxRemovePercent: 85}})
+		if !errors.Is(err, ErrSyncThreshold) {
+			t.Fatalf("batch size %d: expected ErrSyncThreshold, got %v", batchSize, err)
+		}
+		if result.Imported != 0 || result.Created != 0 || result.Updated != 0 || result.Skipped != 2 || result.Removed != 0 {
+			t.Errorf("batch size %d: expected every record skipped, got %+v", batchSize, result)
+		}
+
+		var title string
+		db.QueryRow("SELECT title FROM videos WHERE id = 'v1'").Scan(&title)
+		var count, live int
+		db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+		db.QueryRow("SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL").Scan(&live)
+		if title != "Video 1" || count != 10 || live != 10 {
+			t.Errorf("batch size %d: expected nothing written, got title %q and %d live of %d videos", batchSize, title, live, count)
+		}
+	}
+
+	// Measured against the 10 videos before the import, 90% is allowed
+	result, err := imp.ImportStream(strings.NewReader(input), Options{Sync: &SyncOptions{MaxRemovePercent: 90}})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if result.Created != 1 || result.Updated != 1 || result.Removed != 9 {
+		t.Errorf("expected 1 created, 1 updated and 9 removed, got %+v", result)
+	}
+}
+
+func TestImportStreamSyncStopRollsBack(t *testing.T) {
+	seed := `{"id": "v1", "title": "Video 1"}
+{"id": "v2", "title": "Video 2"}
+`
+	input := `{"id": "v1", "title": "Changed"}
+{"id": "new", "title": "New"}
+`
+	tests := []struct {
+		name  string
+		input string
+		// cancelAfter cancels the import once its first record is read
+		cancelAfter bool
+		err         error
+	}{
+		{"不正な入力", input + "{bad\n", false, ErrMalformed},
+		{"キャンセル", input, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupImporterTestDB(t)
+			defer db.Close()
+
+			imp := New(db)
+			if _, err := imp.ImportStream(strings.NewReader(seed), Options{}); err != nil {
+				t.Fatalf("failed to seed: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var r io.Reader = strings.NewReader(tt.input)
+			if tt.cancelAfter {
+				r = &cancelReader{lines: strings.SplitAfter(tt.input, "\n"), cancel: cancel}
+			}
+			result, err := imp.ImportStreamContext(ctx, r, Options{Format: FormatNDJSON, Sync: &SyncOptions{}})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if result.Imported != 0 || result.Removed != 0 {
+				t.Errorf("expected nothing imported or removed, got %+v", result)
+			}
+
+			var title string
+			db.QueryRow("SELECT title FROM videos WHERE id = 'v1'").Scan(&title)
+			var count, live int
+			db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+			db.QueryRow("SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL").Scan(&live)
+			if title != "Video 1" || count != 2 || live != 2 {
+				t.Errorf("expected nothing written, got title %q and %d live of %d videos", title, live, count)
+			}
+		})
+	}
+}
+
+// cancelReader returns a line per read and calls cancel when it returns
+// the second one, so that the import stops after writing a record.
+type cancelReader struct {
+	lines  []string
+	read   int
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if c.read == len(c.lines) {
+		return 0, io.EOF
+	}
+	if c.read == 1 {
+		c.cancel()
+	}
+	n := copy(p, c.lines[c.read])
+	c.read++
+	return n, nil
+}
+
+func TestImportStreamSyncSource(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	for _, source := range []string{"feed", "manual"} {
+		input := fmt.Sprintf(`{"id": "%s-1", "title": "1"}
+{"id": "%s-2", "title": "2"}`, source, source)
+		if _, err := imp.ImportStream(strings.NewReader(input), Options{Source: source}); err != nil {
+			t.Fatalf("failed to seed: %v", err)
+		}
+	}
+
+	// Only the videos of the feed source are removed
+	result, err := imp.ImportStream(strings.NewReader(`{"id": "feed-1", "title": "1"}`), Options{Source: "feed", Sync: &SyncOptions{}})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if !reflect.DeepEqual(result.Missing, []string{"feed-2"}) || result.Removed != 1 {
+		t.Errorf("expected feed-2 to be removed, got %+v", result)
+	}
+
+	// Importing a soft-deleted video restores it
+	result, err = imp.ImportStream(strings.NewReader(`{"id": "feed-2", "title": "2"}`), Options{})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	var source string
+	var deleted bool
+	db.QueryRow("SELECT source, deleted_at IS NOT NULL FROM videos WHERE id = 'feed-2'").Scan(&source, &deleted)
+	if result.Created != 1 || deleted || source != "feed" {
+		t.Errorf("expected feed-2 to be restored with its source, got %+v, deleted=%v, source=%q", result, deleted, source)
+	}
+}
+
+func TestImportStreamSyncPrefixCase(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	seed := `[
+		{"id": "abc-1", "title": "1"},
+		{"id": "abc-2", "title": "2"},
+		{"id": "ABC-1", "title": "3"},
+		{"id": "Abc-2", "title": "4"},
+		{"id": "abc%1", "title": "5"}
+	]`
+	if _, err := imp.Import([]byte(seed)); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	// The prefix matches case-sensitively and without wildcards
+	result, err := imp.ImportStream(strings.NewReader(`{"id": "abc-1", "title": "1"}`), Options{Sync: &SyncOptions{Prefix: "abc-", Hard: true}})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if !reflect.DeepEqual(result.Missing, []string{"abc-2"}) || result.Removed != 1 {
+		t.Errorf("expected only abc-2 to be removed, got %+v", result)
+	}
+	var stored int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&stored)
+	if stored != 4 {
+		t.Errorf("expected 4 stored videos, got %d", stored)
+	}
+}
+
+func TestImportHistory(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	steps := []struct {
+		input string
+		opts  Options
+	}{
+		{`{"id": "v1", "title": "Video 1", "actors": ["A"], "formats": {"720p": "/a.mp4"}}
+{"id": "v2", "title": "Video 2"}`, Options{}},
+		{`{"id": "v1", "title": "Video 1", "actors": ["A", "B"], "formats": {"1080p": "/b.mp4"}}`, Options{Sync: &SyncOptions{}}},
+		{`{"id": "v1", "title": "Changed"}`, Options{DryRun: true}},
+		{`[{"id": "v1", "title": "Changed"}, {"id": "v3"}]`, Options{Strict: true}},
+	}
+	for _, step := range steps {
+		if _, err := imp.ImportStream(strings.NewReader(step.input), step.opts); err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+	}
+
+	runs, err := imp.Runs(10, 0)
+	if err != nil {
+		t.Fatalf("failed to list runs: %v", err)
+	}
+	if len(runs) != 3 || runs[0].Failed != 1 || runs[1].Removed != 1 || runs[2].Created != 2 || runs[2].FinishedAt == nil {
+		t.Errorf("expected the dry run not to be recorded, got %+v", runs)
+	}
+	sum := sha256.Sum256([]byte(steps[0].input))
+	if runs[2].PayloadHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the payload hash %x, got %s", sum, runs[2].PayloadHash)
+	}
+
+	changes, err := imp.History("v1")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, fmt.Sprintf("%d %s %s->%s", c.RunID, c.Field, c.Old, c.New))
+	}
+	expected := []string{
+		`2 formats.720p /a.mp4->`,
+		`2 formats.1080p ->/b.mp4`,
+		`2 actors ["A"]->["A","B"]`,
+		`1 formats.720p ->/a.mp4`,
+		`1 actors []->["A"]`,
+		`1 title ->Video 1`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected history %q, got %q", expected, got)
+	}
+	if changes, _ := imp.History("v2"); len(changes) != 2 || changes[0].Field != "deleted" || changes[0].New != "soft" {
+		t.Errorf("expected the removal of v2 to be logged, got %+v", changes)
+	}
+}
+
+func TestImportAdvancesUpdatedAt(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	// Writes within the same second, or millisecond, still give every
+	// version of the video its own updated_at, which is its ETag
+	imp := New(db)
+	var last string
+	for i := range 5 {
+		input := fmt.Sprintf(`{"id": "v1", "title": "Video %d"}`, i)
+		if _, err := imp.ImportStream(strings.NewReader(input), Options{}); err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+		var updatedAt string
+		db.QueryRow("SELECT strftime('%Y-%m-%d %H:%M:%f', updated_at) FROM videos WHERE id = 'v1'").Scan(&updatedAt)
+		if i > 0 && updatedAt <= last {
+			t.Errorf("import %d: expected updated_at to advance from %s, got %s", i, last, updatedAt)
+		}
+		last = updatedAt
+	}
+}
+
+func TestImportUnchangedKeepsUpdatedAt(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	input := `{"id": "v1", "title": "Video 1", "actors": ["A"], "tags": ["t"], "formats": {"720p": "/a.mp4"}}`
+	if _, err := imp.ImportStream(strings.NewReader(input), Options{}); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if _, err := db.Exec("UPDATE videos SET updated_at = '2000-01-01 00:00:00'"); err != nil {
+		t.Fatalf("failed to age the video: %v", err)
+	}
+
+	result, err := imp.ImportStream(strings.NewReader(input), Options{})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	var updatedAt string
+	db.QueryRow("SELECT strftime('%Y', updated_at) FROM videos WHERE id = 'v1'").Scan(&updatedAt)
+	if result.Unchanged != 1 || updatedAt != "2000" {
+		t.Errorf("expected an unchanged record to keep updated_at, got %+v and %s", result, updatedAt)
+	}
+
+	// Without a stored hash the record is compared with the video, and the
+	// hash is stored for the next import
+	if _, err := db.Exec("UPDATE videos SET content_hash = ''"); err != nil {
+		t.Fatalf("failed to clear the hash: %v", err)
+	}
+	result, err = imp.ImportStream(strings.NewReader(input), Options{})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	var hash string
+	db.QueryRow("SELECT strftime('%Y', updated_at), content_hash FROM videos WHERE id = 'v1'").Scan(&updatedAt, &hash)
+	if result.Unchanged != 1 || updatedAt != "2000" || hash == "" {
+		t.Errorf("expected the record to be unchanged and hashed, got %+v, %s, %q", result, updatedAt, hash)
+	}
+
+	// A matching hash skips the comparison
+	if _, err := db.Exec("DELETE FROM video_tags"); err != nil {
+		t.Fatalf("failed to unlink tags: %v", err)
+	}
+	result, err = imp.ImportStream(strings.NewReader(input), Options{})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("expected the stored hash to decide, got %+v", result)
+	}
+}
+
+func TestImportStreamJS(t *testing.T) {
+	js := `var movies = [
+  {id: "abc123", dir: "some/dir/", jpg: "thumb.jpg", detail: "pics/", title: "Sample Movie",
+   actors: ["Actor A"], tags: ["tag1"], formats: {"720p": "video_720p.mp4"}},
+];`
+	for _, format := range []string{FormatJS, FormatAuto} {
+		db := setupImporterTestDB(t)
+		result, err := New(db).ImportStream(strings.NewReader(js), Options{Format: format})
+		if err != nil {
+			t.Fatalf("format %q: failed to import: %v", format, err)
+		}
+		var jpg, file string
+		db.QueryRow("SELECT jpg FROM videos WHERE id = 'abc123'").Scan(&jpg)
+		db.QueryRow("SELECT file_path FROM video_formats WHERE video_id = 'abc123'").Scan(&file)
+		if result.Created != 1 || jpg != "/some/dir/thumb.jpg" || file != "/some/dir/video_720p.mp4" {
+			t.Errorf("format %q: unexpected import: %+v, jpg=%s, file=%s", format, result, jpg, file)
+		}
+		db.Close()
+	}
+
+	db := setupImporterTestDB(t)
+	defer db.Close()
+	_, err := New(db).ImportStream(strings.NewReader("movies"), Options{})
+	if !errors.Is(err, ErrMalformed) || !strings.Contains(err.Error(), "convert movies.js") {
+		t.Errorf("expected a malformed movies.js error, got %v", err)
+	}
+}
+
+func TestImportStreamJSWithoutPaths(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	// The converter turns an empty dir and jpg into "/" and "//"
+	js := `var movies = [{id: "abc123", dir: "", jpg: "", title: "Sample Movie"}];`
+	result, err := New(db).ImportStream(strings.NewReader(js), Options{Format: FormatJS})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Created != 1 || result.Failed != 0 {
+		t.Errorf("expected the movie to be created, got %+v", result)
+	}
+}
+
+func TestImportStreamCSV(t *testing.T) {
+	inputs := map[string]string{
+		FormatCSV: "\ufeffid, Title ,actors,Tags,FORMAT:720p\n" +
+			"c1,Video 1,Actor A|Actor B,,c1.mp4\n" +
+			"c2,\"Video, 2\",,tag1 | tag2 |,\n",
+		FormatTSV: "id\ttitle\tactors\ttags\tformat:720p\n" +
+			"c1\tVideo 1\tActor A|Actor B\t\tc1.mp4\n" +
+			"c2\tVideo, 2\t\ttag1|tag2\t\n",
+	}
+	for format, input := range inputs {
+		for _, opt := range []string{format, FormatAuto} {
+			db := setupImporterTestDB(t)
+			result, err := New(db).ImportStream(strings.NewReader(input), Options{Format: opt})
+			if err != nil {
+				t.Fatalf("format %q: failed to import: %v", opt, err)
+			}
+			var title, actors, tags, file string
+			db.QueryRow("SELECT title FROM videos WHERE id = 'c2'").Scan(&title)
+			db.QueryRow("SELECT group_concat(a.name) FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE va.video_id = 'c1'").Scan(&actors)
+			db.QueryRow("SELECT group_concat(t.name) FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE vt.video_id = 'c2'").Scan(&tags)
+			db.QueryRow("SELECT file_path FROM video_formats WHERE video_id = 'c1' AND name = '720p'").Scan(&file)
+			if result.Created != 2 || title != "Video, 2" || actors != "Actor A,Actor B" || tags != "tag1,tag2" || file != "c1.mp4" {
+				t.Errorf("format %q: unexpected import: %+v, title=%s, actors=%s, tags=%s, file=%s", opt, result, title, actors, tags, file)
+			}
+			db.Close()
+		}
+	}
+
+	db := setupImporterTestDB(t)
+	defer db.Close()
+	for _, input := range []string{"id,name\nc1,x\n", "title\nVideo\n", "id,id\n", "id,title\nc1,\"x\n"} {
+		_, err := New(db).ImportStream(strings.NewReader(input), Options{Format: FormatCSV})
+		if !errors.Is(err, ErrMalformed) {
+			t.Errorf("%q: expected a malformed input error, got %v", input, err)
+		}
+	}
+}
+
+func TestImportStreamCSVRows(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	// Cells keep their spaces, and a row with the wrong number of fields
+	// fails alone
+	input := "id,title,url\n" +
+		"c1, Spaced Title ,https://example.com/1 \n" +
+		"c2,Too,Many,Fields\n" +
+		"c3\n" +
+		"c4,Video 4,\n"
+	result, err := New(db).ImportStream(strings.NewReader(input), Options{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Records != 4 || result.Created != 2 || result.Failed != 2 {
+		t.Errorf("expected 2 created and 2 failed of 4 records, got %+v", result)
+	}
+	var failed []string
+	for _, issue := range result.Issues {
+		if issue.Status == StatusFailed && strings.Contains(issue.Reason, "wrong number of fields") {
+			failed = append(failed, fmt.Sprintf("%d:%s", issue.Index, issue.ID))
+		}
+	}
+	if strings.Join(failed, " ") != "2:c2 3:c3" {
+		t.Errorf("expected records 2 and 3 to fail, got %+v", result.Issues)
+	}
+
+	var title, url string
+	db.QueryRow("SELECT title, url FROM videos WHERE id = 'c1'").Scan(&title, &url)
+	if title != " Spaced Title " || url != "https://example.com/1 " {
+		t.Errorf("expected the cells as written, got %q and %q", title, url)
+	}
+}
+
+func TestImportStreamCSVPartialColumns(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	imp := New(db)
+	if _, err := imp.Import([]byte(`[{"id": "c1", "title": "Video 1", "url": "https://example.com/1",
+		"actors": ["Actor A"], "tags": ["tag1", "tag2"], "formats": {"720p": "c1.mp4"}}]`)); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	// The columns the header leaves out keep their stored values
+	result, err := imp.ImportStream(strings.NewReader("id,title,url\nc1,Renamed,https://example.com/one\n"), Options{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Updated != 1 || result.Failed != 0 {
+		t.Errorf("expected 1 updated, got %+v", result)
+	}
+	video, err := loadTestVideo(db, "c1")
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if video.Title != "Renamed" || video.URL != "https://example.com/one" ||
+		fmt.Sprint(video.Actors) != "[Actor A]" || fmt.Sprint(video.Tags) != "[tag1 tag2]" ||
+		fmt.Sprint(video.Formats) != "map[720p:c1.mp4]" {
+		t.Errorf("expected actors, tags and formats to be kept, got %+v", video)
+	}
+
+	// Without a title column, only new videos need one
+	result, err = imp.ImportStream(strings.NewReader("id,tags\nc1,tag3\nc2,tag3\n"), Options{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if result.Updated != 1 || result.Failed != 1 || len(result.Issues) != 1 ||
+		result.Issues[0].ID != "c2" || result.Issues[0].Reason != "title is required" {
+		t.Errorf("expected c1 updated and c2 to fail, got %+v", result)
+	}
+	video, _ = loadTestVideo(db, "c1")
+	if video.Title != "Renamed" || fmt.Sprint(video.Tags) != "[tag3]" || fmt.Sprint(video.Actors) != "[Actor A]" {
+		t.Errorf("expected only the tags to change, got %+v", video)
+	}
+}
+
+// loadTestVideo reads a stored video as an import record.
+func loadTestVideo(db *database.DB, id string) (videoJSON, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return videoJSON{}, err
+	}
+	defer tx.Rollback()
+	return loadVideo(tx, id)
+}
+
+func TestImportRating(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+	imp := New(db)
+
+	rating := func() int {
+		var r int
+		db.QueryRow("SELECT COALESCE((SELECT rating FROM ratings WHERE video_id = 'r1'), 0)").Scan(&r)
+		return r
+	}
+	steps := []struct {
+		input  string
+		status string
+		rating int
+	}{
+		{`{"id": "r1", "title": "Video", "rating": 3}`, StatusCreated, 3},
+		// Unchanged content with a new rating is an update
+		{`{"id": "r1", "title": "Video", "rating": 5}`, StatusUpdated, 5},
+		{`{"id": "r1", "title": "Video"}`, StatusUnchanged, 5},
+		{`{"id": "r1", "title": "Video", "rating": 5}`, StatusUnchanged, 5},
+		{`{"id": "r1", "title": "Video", "rating": 0}`, StatusUpdated, 0},
+	}
+	for _, step := range steps {
+		result, err := imp.ImportStream(strings.NewReader(step.input), Options{})
+		if err != nil {
+			t.Fatalf("%s: failed to import: %v", step.input, err)
+		}
+		status := map[string]int{StatusCreated: result.Created, StatusUpdated: result.Updated, StatusUnchanged: result.Unchanged}
+		if status[step.status] != 1 || rating() != step.rating {
+			t.Errorf("%s: expected %s with rating %d, got %+v with rating %d", step.input, step.status, step.rating, result, rating())
+		}
+	}
+
+	changes, err := imp.History("r1")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if changes[0].Field != "rating" || changes[0].Old != "5" || changes[0].New != "" {
+		t.Errorf("expected the rating removal in the history, got %+v", changes[0])
+	}
+
+	result, _ := imp.ImportStream(strings.NewReader(`{"id": "r2", "title": "Video", "rating": 6}`), Options{})
+	if result.Failed != 1 {
+		t.Errorf("expected an out of range rating to fail, got %+v", result)
+	}
+}
@@ -0,0 +1,292 @@
+package importer
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Import job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// ErrJobFinished is returned when cancelling a job that is no longer queued
+// or running.
+var ErrJobFinished = errors.New("import job already finished")
+
+// Job is an import run in the background. While the job runs, Result
+// holds the counts of the records processed so far, without their issues.
+type Job struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Options    Options    `json:"options"`
+	Result     Result     `json:"result"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Jobs runs imports in the background, one at a time in the order they
+// were started, and records them in the import_jobs table. The input of a job is spooled to a temporary file
+// first, so a job does not depend on the client that started it.
+type Jobs struct {
+	imp *Importer
+	dir string
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	// last is closed when the job started last has finished. Each job
+	// waits for the one before it, so jobs run in order.
+	last chan struct{}
+	// progress holds the result so far of the running job, which is only
+	// written to import_jobs when it finishes.
+	progress map[string]Result
+	wg       sync.WaitGroup
+}
+
+// NewJobs returns a job runner spooling inputs to dir, or the default
+// temporary directory when dir is empty. Jobs left queued or running by a
+// previous process are marked failed.
+func NewJobs(imp *Importer, dir string) *Jobs {
+	_, err := imp.db.Exec(`UPDATE import_jobs SET state = $1, error = 'interrupted by a server restart', finished_at = CURRENT_TIMESTAMP
+		WHERE state IN ($2, $3)`, JobFailed, JobQueued, JobRunning)
+	if err != nil {
+		log.Printf("import jobs: %v", err)
+	}
+	return &Jobs{imp: imp, dir: dir, cancels: map[string]context.CancelFunc{}, progress: map[string]Result{}}
+}
+
+// Start spools r and queues a job importing it with opts.
+func (j *Jobs) Start(r io.Reader, opts Options) (*Job, error) {
+	f, err := os.CreateTemp(j.dir, "import-*")
+	if err != nil {
+		return nil, err
+	}
+	path := f.Name()
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	optionsJSON, err := json.Marshal(opts)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	id := rand.Text()
+	if _, err := j.imp.db.Exec("INSERT INTO import_jobs (id, state, options) VALUES ($1, $2, $3)", id, JobQueued, string(optionsJSON)); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	j.mu.Lock()
+	j.cancels[id] = cancel
+	prev := j.last
+	j.last = done
+	j.mu.Unlock()
+	j.wg.Add(1)
+	go j.run(ctx, id, path, opts, prev, done)
+	return j.Get(id)
+}
+
+// run waits for prev, unless it is nil, runs the job and closes done.
+func (j *Jobs) run(ctx context.Context, id, path string, opts Options, prev <-chan struct{}, done chan<- struct{}) {
+	defer j.wg.Done()
+	defer os.Remove(path)
+	defer func() {
+		j.mu.Lock()
+		j.cancels[id]()
+		delete(j.cancels, id)
+		delete(j.progress, id)
+		j.mu.Unlock()
+	}()
+
+	if prev != nil {
+		<-prev
+	}
+	defer close(done)
+	if ctx.Err() != nil {
+		j.finish(id, JobCancelled, Result{}, nil)
+		return
+	}
+	j.exec("UPDATE import_jobs SET state = $2, started_at = CURRENT_TIMESTAMP WHERE id = $1", id, JobRunning)
+
+	f, err := os.Open(path)
+	if err != nil {
+		j.finish(id, JobFailed, Result{}, err)
+		return
+	}
+	defer f.Close()
+
+	progress := opts.Progress
+	opts.Progress = func(r Result) {
+		// Writing it to import_jobs would wait for the import's transaction
+		p := r
+		p.Issues, p.Diffs, p.Missing = []RecordIssue{}, nil, nil
+		j.mu.Lock()
+		j.progress[id] = p
+		j.mu.Unlock()
+		if progress != nil {
+			progress(r)
+		}
+	}
+	result, err := j.imp.ImportStreamContext(ctx, f, opts)
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.finish(id, JobCancelled, result, nil)
+	case err != nil:
+		j.finish(id, JobFailed, result, err)
+	default:
+		j.finish(id, JobCompleted, result, nil)
+	}
+}
+
+func (j *Jobs) finish(id, state string, result Result, err error) {
+	data, merr := json.Marshal(result)
+	if merr != nil {
+		log.Printf("import job %s: %v", id, merr)
+		data = []byte("{}")
+	}
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	j.exec("UPDATE import_jobs SET state = $2, result = $3, error = $4, finished_at = CURRENT_TIMESTAMP WHERE id = $1",
+		id, state, string(data), msg)
+}
+
+// exec updates a job row. Failures are logged, since the job itself goes on.
+func (j *Jobs) exec(query string, args ...interface{}) {
+	if _, err := j.imp.db.Exec(query, args...); err != nil {
+		log.Printf("import job %s: %v", args[0], err)
+	}
+}
+
+// Cancel asks a queued or running job to stop and returns it. A running job
+// keeps the batches it already committed unless it is strict. It returns
+// sql.ErrNoRows for an unknown job and ErrJobFinished for a finished one.
+func (j *Jobs) Cancel(id string) (*Job, error) {
+	j.mu.Lock()
+	cancel, ok := j.cancels[id]
+	j.mu.Unlock()
+	if !ok {
+		job, err := j.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return job, ErrJobFinished
+	}
+	cancel()
+	return j.Get(id)
+}
+
+// Wait blocks until every started job has finished.
+func (j *Jobs) Wait() {
+	j.wg.Wait()
+}
+
+// Shutdown waits for the started jobs to finish. When ctx is done first, it
+// cancels them, waits for them to record their state and returns the error
+// of ctx. No job may be started once Shutdown is called.
+func (j *Jobs) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	j.mu.Lock()
+	for _, cancel := range j.cancels {
+		cancel()
+	}
+	j.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// withProgress sets the result of a running job to its progress.
+func (j *Jobs) withProgress(job *Job) *Job {
+	if job.State != JobRunning {
+		return job
+	}
+	j.mu.Lock()
+	if r, ok := j.progress[job.ID]; ok {
+		job.Result = r
+	}
+	j.mu.Unlock()
+	return job
+}
+
+const jobColumns = "id, state, options, result, error, created_at, started_at, finished_at"
+
+// Get returns a job, or sql.ErrNoRows when there is none with the ID.
+func (j *Jobs) Get(id string) (*Job, error) {
+	job, err := scanJob(j.imp.db.QueryRow("SELECT "+jobColumns+" FROM import_jobs WHERE id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	return j.withProgress(job), nil
+}
+
+// List returns the most recent jobs first, at most limit of them.
+func (j *Jobs) List(limit int) ([]Job, error) {
+	rows, err := j.imp.db.Query("SELECT "+jobColumns+" FROM import_jobs ORDER BY created_at DESC, rowid DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *j.withProgress(job))
+	}
+	return jobs, rows.Err()
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var options, result string
+	var started, finished sql.NullTime
+	if err := row.Scan(&job.ID, &job.State, &options, &result, &job.Error, &job.CreatedAt, &started, &finished); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(options), &job.Options); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(result), &job.Result); err != nil {
+		return nil, err
+	}
+	if started.Valid {
+		job.StartedAt = &started.Time
+	}
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	return &job, nil
+}
@@ -0,0 +1,250 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iwaco/movies/internal/database"
+)
+
+// setupJobsTestDB uses a file, since jobs query from other goroutines and
+// every connection to :memory: opens an empty database.
+func setupJobsTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	return db
+}
+
+func TestJobs(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	jobs := NewJobs(New(db), t.TempDir())
+	input := `{"id": "v1", "title": "Video 1"}
+{"id": "v2", "title": ""}
+{"id": "v3", "title": "Video 3"}`
+	job, err := jobs.Start(strings.NewReader(input), Options{BatchSize: 1})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if job.ID == "" || job.Options.BatchSize != 1 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	jobs.Wait()
+
+	job, err = jobs.Get(job.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if job.State != JobCompleted || job.Result.Imported != 2 || job.Result.Failed != 1 || job.StartedAt == nil || job.FinishedAt == nil {
+		t.Errorf("unexpected finished job: %+v", job)
+	}
+	if _, err := jobs.Cancel(job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("expected ErrJobFinished, got %v", err)
+	}
+	if _, err := jobs.Get("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	if _, err := jobs.Start(strings.NewReader("{"), Options{}); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	jobs.Wait()
+	list, err := jobs.List(10)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(list) != 2 || list[0].State != JobFailed || list[0].Error == "" || list[1].ID != job.ID {
+		t.Errorf("unexpected jobs: %+v", list)
+	}
+}
+
+func TestJobsProgress(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	jobs := NewJobs(New(db), t.TempDir())
+	reached, release := make(chan struct{}), make(chan struct{})
+	input := `{"id": "v1", "title": "Video 1"}
+{"id": "v2", "title": ""}
+{"id": "v3", "title": "Video 3"}`
+	// A strict job commits only at the end
+	job, err := jobs.Start(strings.NewReader(input), Options{Strict: true, Progress: func(r Result) {
+		if r.Records == 2 && r.Imported == 0 {
+			close(reached)
+			<-release
+		}
+	}})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	<-reached
+	running, err := jobs.Get(job.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if running.State != JobRunning || running.Result.Records != 2 || running.Result.Created != 1 || running.Result.Failed != 1 {
+		t.Errorf("expected the progress of 2 records, got %+v", running)
+	}
+	list, err := jobs.List(10)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(list) != 1 || list[0].Result.Records != 2 {
+		t.Errorf("expected the listed job to have its progress, got %+v", list)
+	}
+	close(release)
+	jobs.Wait()
+
+	if job, _ = jobs.Get(job.ID); job.State != JobCompleted || job.Result.Records != 3 || len(job.Result.Issues) != 3 {
+		t.Errorf("unexpected finished job: %+v", job)
+	}
+}
+
+func TestJobsShutdown(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	jobs := NewJobs(New(db), t.TempDir())
+	if err := jobs.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected nothing to wait for, got %v", err)
+	}
+
+	reached, release := make(chan struct{}), make(chan struct{})
+	job, err := jobs.Start(strings.NewReader(`{"id": "v1", "title": "Video 1"}
+{"id": "v2", "title": "Video 2"}`), Options{Strict: true, Progress: func(r Result) {
+		if r.Records == 1 {
+			close(reached)
+			<-release
+		}
+	}})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	<-reached
+
+	// A job still running when ctx is done is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error)
+	go func() { done <- jobs.Shutdown(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if job, _ = jobs.Get(job.ID); job.State != JobCancelled {
+		t.Errorf("expected a cancelled job, got %s", job.State)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the strict job to be rolled back, got %d videos", count)
+	}
+}
+
+func TestJobsCancelQueued(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	jobs := NewJobs(New(db), t.TempDir())
+	// Hold the job queued behind another one
+	block := make(chan struct{})
+	jobs.last = block
+	job, err := jobs.Start(strings.NewReader(`{"id": "v1", "title": "Video 1"}`), Options{})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if job.State != JobQueued {
+		t.Errorf("expected a queued job, got %s", job.State)
+	}
+	if _, err := jobs.Cancel(job.ID); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	close(block)
+	jobs.Wait()
+
+	if job, _ = jobs.Get(job.ID); job.State != JobCancelled {
+		t.Errorf("expected a cancelled job, got %s", job.State)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM videos").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected nothing imported, got %d videos", count)
+	}
+}
+
+func TestJobsRunInOrder(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	jobs := NewJobs(New(db), t.TempDir())
+	block := make(chan struct{})
+	jobs.last = block
+	var mu sync.Mutex
+	var order []int
+	for i := range 10 {
+		input := fmt.Sprintf(`{"id": "v%d", "title": "Video"}`, i)
+		_, err := jobs.Start(strings.NewReader(input), Options{Progress: func(r Result) {
+			if r.Imported > 0 {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+			}
+		}})
+		if err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+	}
+	close(block)
+	jobs.Wait()
+
+	if fmt.Sprint(order) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("expected the jobs to run in the order they were started, got %v", order)
+	}
+}
+
+func TestNewJobsFailsInterruptedJobs(t *testing.T) {
+	db := setupJobsTestDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO import_jobs (id, state) VALUES ('j1', 'running'), ('j2', 'completed')"); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+	jobs := NewJobs(New(db), t.TempDir())
+	for id, state := range map[string]string{"j1": JobFailed, "j2": JobCompleted} {
+		job, err := jobs.Get(id)
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+		if job.State != state {
+			t.Errorf("%s: expected %s, got %s", id, state, job.State)
+		}
+	}
+}
+
+func TestImportStreamContextCancelled(t *testing.T) {
+	db := setupImporterTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := New(db).ImportStreamContext(ctx, strings.NewReader(`{"id": "v1", "title": "Video 1"}`), Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if result.Records != 0 {
+		t.Errorf("expected nothing decoded, got %+v", result)
+	}
+}
@@ -0,0 +1,293 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/iwaco/movies/internal/database"
+)
+
+// validate checks a record before it is written. seen maps the IDs of the
+// records before it to their 1-based index, to catch duplicates.
+func validate(v videoJSON, seen map[string]int) error {
+	if strings.TrimSpace(v.ID) == "" {
+		return errors.New("id is required")
+	}
+	if first, ok := seen[v.ID]; ok {
+		return fmt.Errorf("duplicate id, first seen in record %d", first)
+	}
+	// A kept title is checked once the stored video is loaded
+	if strings.TrimSpace(v.Title) == "" && !slices.Contains(v.keep, "title") {
+		return errors.New("title is required")
+	}
+	if v.Date != "" {
+		if _, err := time.Parse("2006-01-02", v.Date); err != nil {
+			return fmt.Errorf("date %q is not in YYYY-MM-DD format", v.Date)
+		}
+	}
+	if v.Rating != nil && (*v.Rating < 0 || *v.Rating > 5) {
+		return fmt.Errorf("rating %d is not between 0 and 5", *v.Rating)
+	}
+	paths := []struct {
+		field string
+		value string
+	}{
+		{"jpg", v.JPG},
+		{"pictures_dir", v.PicturesDir},
+	}
+	for _, name := range slices.Sorted(maps.Keys(v.Formats)) {
+		paths = append(paths, struct {
+			field string
+			value string
+		}{"formats." + name, v.Formats[name]})
+	}
+	for _, p := range paths {
+		if !validMediaPath(p.value) {
+			return fmt.Errorf("%s %q is not a path under the media root", p.field, p.value)
+		}
+	}
+	return nil
+}
+
+// validMediaPath reports whether p is empty or a path under the media root,
+// with or without a leading slash. Slashes alone count as empty, as
+// converted from a legacy movie without a dir or jpg.
+func validMediaPath(p string) bool {
+	if strings.Trim(p, "/") == "" {
+		return true
+	}
+	if strings.ContainsAny(p, "\x00\\") || strings.Contains(p, "://") {
+		return false
+	}
+	return filepath.IsLocal(strings.TrimPrefix(p, "/"))
+}
+
+// loadVideo reads a stored video in the shape of an import record, with
+// actors and tags sorted by name. It returns sql.ErrNoRows when there is no
+// video with the ID or it is soft-deleted.
+func loadVideo(tx *sql.Tx, id string) (videoJSON, error) {
+	v := videoJSON{ID: id, Formats: map[string]string{}, Rating: new(int)}
+	err := tx.QueryRow(`SELECT title, url, date, jpg, pictures_dir, source, COALESCE((SELECT rating FROM ratings WHERE video_id = videos.id), 0)
+		FROM videos WHERE id = $1 AND deleted_at IS NULL`, id).
+		Scan(&v.Title, &v.URL, &v.Date, &v.JPG, &v.PicturesDir, &v.Source, v.Rating)
+	if err != nil {
+		return v, err
+	}
+
+	for _, q := range []struct {
+		query string
+		names *[]string
+	}{
+		{"SELECT a.name FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE va.video_id = $1 ORDER BY a.name", &v.Actors},
+		{"SELECT t.name FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE vt.video_id = $1 ORDER BY t.name", &v.Tags},
+	} {
+		rows, err := tx.Query(q.query, id)
+		if err != nil {
+			return v, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return v, err
+			}
+			*q.names = append(*q.names, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return v, err
+		}
+	}
+
+	rows, err := tx.Query("SELECT name, file_path FROM video_formats WHERE video_id = $1", id)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, path string
+		if err := rows.Scan(&name, &path); err != nil {
+			return v, err
+		}
+		v.Formats[name] = path
+	}
+	return v, rows.Err()
+}
+
+// fillKept sets the fields v keeps to those of the stored video. A new
+// video leaves them empty, except for its title, which is required.
+func fillKept(tx *sql.Tx, v videoJSON) (videoJSON, error) {
+	if len(v.keep) == 0 {
+		return v, nil
+	}
+	old, err := loadVideo(tx, v.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if slices.Contains(v.keep, "title") {
+			return v, errors.New("title is required")
+		}
+		return v, nil
+	}
+	if err != nil {
+		return v, err
+	}
+	for _, field := range v.keep {
+		switch field {
+		case "title":
+			v.Title = old.Title
+		case "url":
+			v.URL = old.URL
+		case "date":
+			v.Date = old.Date
+		case "actors":
+			v.Actors = old.Actors
+		case "tags":
+			v.Tags = old.Tags
+		case "jpg":
+			v.JPG = old.JPG
+		case "pictures_dir":
+			v.PicturesDir = old.PicturesDir
+		case "formats":
+			v.Formats = old.Formats
+		}
+	}
+	return v, nil
+}
+
+// canonical returns v as it would be stored: actor and tag names resolved
+// through aliases, deduplicated and sorted, and formats never nil.
+func canonical(tx *sql.Tx, v videoJSON) (videoJSON, error) {
+	var err error
+	if v.Actors, err = canonicalNames(tx, database.Actors, v.Actors); err != nil {
+		return v, err
+	}
+	if v.Tags, err = canonicalNames(tx, database.Tags, v.Tags); err != nil {
+		return v, err
+	}
+	if v.Formats == nil {
+		v.Formats = map[string]string{}
+	}
+	return v, nil
+}
+
+func canonicalNames(tx *sql.Tx, names database.NameTable, in []string) ([]string, error) {
+	var out []string
+	for _, name := range in {
+		id, err := names.Lookup(tx, name)
+		if err == nil {
+			err = tx.QueryRow(fmt.Sprintf("SELECT name FROM %s WHERE id = $1", names.Table), id).Scan(&name)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// contentHash hashes a canonical record, leaving out its source and rating,
+// which are compared on their own. The keys of the formats map are encoded
+// sorted, so equal records hash equally.
+func contentHash(v videoJSON) (string, error) {
+	v.Source, v.Rating = "", nil
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// syncScope is the FROM and WHERE part of a query for the stored videos a
+// sync import may remove, whose ID starts with $1 and, unless $2 is empty,
+// whose source is $2. The prefix is compared exactly, since LIKE would
+// ignore its case.
+const syncScope = `FROM videos
+	WHERE deleted_at IS NULL AND substr(id, 1, length($1)) = $1 AND ($2 = '' OR source = $2)`
+
+// countInScope returns how many stored videos are in the scope of a sync
+// import with opts.
+func countInScope(tx *sql.Tx, opts Options) (int, error) {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) "+syncScope, opts.Sync.Prefix, opts.Source).Scan(&n)
+	return n, err
+}
+
+// missingVideos returns the IDs of the stored videos in scope that are not
+// in present, sorted.
+func missingVideos(tx *sql.Tx, present map[string]bool, prefix, source string) ([]string, error) {
+	rows, err := tx.Query("SELECT id "+syncScope+" ORDER BY id", prefix, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	missing := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if !present[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, rows.Err()
+}
+
+// removeMissing sets result.Missing and, unless opts is a dry run, deletes
+// the missing videos of a sync import. A dry run without Sync considers
+// every stored video. inScope is the number of videos in scope before the
+// import, which the threshold is measured against, so that the videos the
+// import creates do not count.
+func removeMissing(tx *sql.Tx, present map[string]bool, opts Options, inScope int, result *Result) error {
+	sync := SyncOptions{}
+	source := ""
+	if opts.Sync != nil {
+		sync, source = *opts.Sync, opts.Source
+	}
+	missing, err := missingVideos(tx, present, sync.Prefix, source)
+	if err != nil {
+		return err
+	}
+	result.Missing = missing
+	if opts.Sync == nil || len(missing) == 0 {
+		return nil
+	}
+	if sync.MaxRemovePercent > 0 && !sync.Force && len(missing)*100 > sync.MaxRemovePercent*inScope {
+		return fmt.Errorf("%w: %d of %d videos are missing, more than %d%%", ErrSyncThreshold, len(missing), inScope, sync.MaxRemovePercent)
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	data, err := json.Marshal(missing)
+	if err != nil {
+		return err
+	}
+	queries := []string{"UPDATE videos SET deleted_at = CURRENT_TIMESTAMP WHERE id IN (SELECT value FROM json_each($1))"}
+	if sync.Hard {
+		queries = []string{
+			"DELETE FROM videos_fts WHERE video_id IN (SELECT value FROM json_each($1))",
+			"DELETE FROM videos WHERE id IN (SELECT value FROM json_each($1))",
+		}
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q, string(data)); err != nil {
+			return err
+		}
+	}
+	if err := recordRemovals(tx, result.RunID, string(data), sync.Hard); err != nil {
+		return err
+	}
+	result.Removed = len(missing)
+	return nil
+}
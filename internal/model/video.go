@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Video struct {
 	ID          string        `json:"id"`
@@ -13,8 +16,10 @@ type Video struct {
 	Tags        []Tag         `json:"tags"`
 	Formats     []VideoFormat `json:"formats"`
 	Rating      int           `json:"rating"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
+	// Source is the label given by the import that wrote the video.
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Actor struct {
@@ -34,16 +39,35 @@ type VideoFormat struct {
 }
 
 type VideoQueryParams struct {
-	Page     int
-	PerPage  int
-	Query    string
-	Tags     []string
-	Actors   []string
-	DateFrom string
-	DateTo   string
-	Sort     string
+	Page    int
+	PerPage int
+	Query   string
+	Tags    []string
+	// TagMode is "all" (default) to require every tag in Tags or "any" to
+	// require at least one of them. ActorMode does the same for Actors.
+	TagMode       string
+	ExcludeTags   []string
+	Actors        []string
+	ActorMode     string
+	ExcludeActors []string
+	DateFrom      string
+	DateTo        string
+	Sort          string
+	// Seed fixes the order of the random sort so that its pages line up.
+	Seed      int64
 	MinRating int
 	HasVideo  bool
+	// Cursor is a NextCursor from a previous result. When set, the page
+	// continues after that result instead of being selected by Page.
+	Cursor    string
+	SkipTotal bool
+}
+
+// Filtered reports whether any filter narrows the videos selected by p.
+func (p VideoQueryParams) Filtered() bool {
+	return p.Query != "" || len(p.Tags) > 0 || len(p.ExcludeTags) > 0 ||
+		len(p.Actors) > 0 || len(p.ExcludeActors) > 0 ||
+		p.DateFrom != "" || p.DateTo != "" || p.MinRating > 0 || p.HasVideo
 }
 
 type VideoListResult struct {
@@ -52,4 +76,136 @@ type VideoListResult struct {
 	Page       int     `json:"page"`
 	PerPage    int     `json:"per_page"`
 	TotalPages int     `json:"total_pages"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Seed       int64   `json:"seed,omitempty"`
+	// TotalSkipped is set when the total was not counted; total and
+	// total_pages are then left out of the JSON.
+	TotalSkipped bool `json:"-"`
+}
+
+func (r VideoListResult) MarshalJSON() ([]byte, error) {
+	type result VideoListResult
+	if !r.TotalSkipped {
+		return json.Marshal(result(r))
+	}
+	return json.Marshal(struct {
+		result
+		Total      *int `json:"total,omitempty"`
+		TotalPages *int `json:"total_pages,omitempty"`
+	}{result: result(r)})
+}
+
+// VideoUpdate is a partial update of a video; nil fields are left unchanged.
+// Actors and Tags replace the current lists, while AddActors, RemoveActors,
+// AddTags and RemoveTags change individual entries.
+type VideoUpdate struct {
+	Title        *string   `json:"title"`
+	URL          *string   `json:"url"`
+	Date         *string   `json:"date"`
+	JPG          *string   `json:"jpg"`
+	PicturesDir  *string   `json:"pictures_dir"`
+	Actors       *[]string `json:"actors"`
+	AddActors    []string  `json:"add_actors"`
+	RemoveActors []string  `json:"remove_actors"`
+	Tags         *[]string `json:"tags"`
+	AddTags      []string  `json:"add_tags"`
+	RemoveTags   []string  `json:"remove_tags"`
+	// UpdatedAt, when set, must equal the video's current updated_at for
+	// the update to be applied.
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// VideoSorts lists the accepted values of VideoQueryParams.Sort.
+var VideoSorts = []string{
+	"date_desc", "date_asc", "title_asc", "title_desc", "relevance",
+	"rating_desc", "rating_asc", "created_desc", "updated_desc", "random",
+}
+
+// FacetNames lists the facets that can be counted for a video query.
+var FacetNames = []string{"tag", "actor", "year", "rating", "format"}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type VideoFacets struct {
+	Total  int                     `json:"total"`
+	Facets map[string][]FacetCount `json:"facets"`
+}
+
+// PruneResult counts the rows removed by a prune.
+type PruneResult struct {
+	Actors int `json:"actors"`
+	Tags   int `json:"tags"`
+	FTS    int `json:"fts"`
+}
+
+// ActorProfile is an actor with its profile, aliases and video statistics.
+type ActorProfile struct {
+	Actor
+	// Kana and Romaji are alternate readings of the name.
+	Kana   string `json:"kana"`
+	Romaji string `json:"romaji"`
+	// ImagePath is relative to the media root, like Video.JPG.
+	ImagePath string     `json:"image_path"`
+	Notes     string     `json:"notes"`
+	Aliases   []string   `json:"aliases"`
+	Stats     ActorStats `json:"stats"`
+}
+
+type ActorStats struct {
+	VideoCount int `json:"video_count"`
+	// FirstDate and LastDate span the dates of the actor's videos.
+	FirstDate     string   `json:"first_date"`
+	LastDate      string   `json:"last_date"`
+	RatedCount    int      `json:"rated_count"`
+	AverageRating *float64 `json:"average_rating"`
+}
+
+// ActorUpdate is a partial update of an actor; nil fields are left
+// unchanged.
+type ActorUpdate struct {
+	Name      *string `json:"name"`
+	Kana      *string `json:"kana"`
+	Romaji    *string `json:"romaji"`
+	ImagePath *string `json:"image_path"`
+	Notes     *string `json:"notes"`
+}
+
+// NameQueryParams selects and orders the entries of the tag and actor
+// lists.
+type NameQueryParams struct {
+	// Query matches names containing it, ignoring case and width; names
+	// starting with it are listed first.
+	Query string
+	// Sort is "name" (default) or "count", the number of videos descending.
+	Sort string
+	// Limit is the maximum number of entries, or 0 for all of them.
+	Limit     int
+	Offset    int
+	HideEmpty bool
+}
+
+// NameSorts lists the accepted values of NameQueryParams.Sort.
+var NameSorts = []string{"name", "count"}
+
+type TagSummary struct {
+	Tag
+	VideoCount int `json:"video_count"`
+}
+
+type ActorSummary struct {
+	Actor
+	VideoCount int `json:"video_count"`
+}
+
+type TagList struct {
+	Tags  []TagSummary `json:"tags"`
+	Total int          `json:"total"`
+}
+
+type ActorList struct {
+	Actors []ActorSummary `json:"actors"`
+	Total  int            `json:"total"`
 }
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/iwaco/movies/internal/model"
+)
+
+// actorProfileColumns are the actor columns besides its name, in the order
+// Get scans them.
+var actorProfileColumns = []string{"kana", "romaji", "image_path", "notes"}
+
+// ActorRepository manages actor profiles, renaming, merging and aliases.
+// Unlike tag aliases, actor readings and aliases are indexed in videos_fts
+// so that searching any of them finds the actor's videos.
+type ActorRepository struct {
+	db *sql.DB
+}
+
+func NewActorRepository(db *sql.DB) *ActorRepository {
+	return &ActorRepository{db: db}
+}
+
+// Get returns an actor's profile with its aliases and statistics over its
+// videos. It returns sql.ErrNoRows when the actor does not exist.
+func (r *ActorRepository) Get(id int) (*model.ActorProfile, error) {
+	var a model.ActorProfile
+	err := r.db.QueryRow("SELECT id, name, kana, romaji, image_path, notes FROM actors WHERE id = $1", id).
+		Scan(&a.ID, &a.Name, &a.Kana, &a.Romaji, &a.ImagePath, &a.Notes)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Aliases, err = listAliases(r.db, actorRelation, id); err != nil {
+		return nil, err
+	}
+
+	var avg sql.NullFloat64
+	err = r.db.QueryRow(`SELECT COUNT(*), COALESCE(MIN(NULLIF(v.date, '')), ''), COALESCE(MAX(NULLIF(v.date, '')), ''),
+		COUNT(rt.rating), AVG(rt.rating)
+		FROM video_actors va JOIN videos v ON v.id = va.video_id LEFT JOIN ratings rt ON rt.video_id = v.id
+		WHERE va.actor_id = $1 AND v.deleted_at IS NULL`, id).
+		Scan(&a.Stats.VideoCount, &a.Stats.FirstDate, &a.Stats.LastDate, &a.Stats.RatedCount, &avg)
+	if err != nil {
+		return nil, err
+	}
+	if avg.Valid {
+		a.Stats.AverageRating = &avg.Float64
+	}
+	return &a, nil
+}
+
+// Update applies a partial update to an actor. A new name keeps the old
+// one as an alias and advances updated_at of the actor's videos. It returns
+// sql.ErrNoRows when the actor does not exist and ErrNameTaken when the name
+// belongs to another actor.
+func (r *ActorRepository) Update(id int, u model.ActorUpdate) (*model.ActorProfile, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkNamedExists(tx, actorRelation, id); err != nil {
+		return nil, err
+	}
+	if u.Name != nil {
+		if err := renameNamed(tx, actorRelation, id, *u.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	sets := []string{}
+	args := []interface{}{id}
+	for i, value := range []*string{u.Kana, u.Romaji, u.ImagePath, u.Notes} {
+		if value != nil {
+			args = append(args, *value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", actorProfileColumns[i], len(args)))
+		}
+	}
+	if len(sets) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE actors SET %s WHERE id = $1", strings.Join(sets, ", ")), args...); err != nil {
+			return nil, err
+		}
+	}
+	if u.Kana != nil || u.Romaji != nil {
+		if err := reindexLinked(tx, actorRelation, []int{id}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(id)
+}
+
+// Merge merges the actors sources into target: their videos are linked to
+// target instead, their names and aliases become aliases of target and
+// profile fields target leaves empty are taken from them. The videos of
+// sources have their updated_at advanced. It returns sql.ErrNoRows when any
+// of the actors does not exist.
+func (r *ActorRepository) Merge(target int, sources []int) (*model.ActorProfile, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	data, err := json.Marshal(sources)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range actorProfileColumns {
+		_, err := tx.Exec(fmt.Sprintf(`UPDATE actors SET %[1]s = COALESCE((SELECT %[1]s FROM actors
+			WHERE id IN (SELECT value FROM json_each($2)) AND %[1]s != '' ORDER BY id LIMIT 1), '')
+			WHERE id = $1 AND %[1]s = ''`, column), target, string(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := mergeNamed(tx, actorRelation, target, sources); err != nil {
+		return nil, err
+	}
+	// The merged names are indexed as aliases on all of target's videos
+	if err := reindexLinked(tx, actorRelation, []int{target}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(target)
+}
+
+// AddAlias makes name an alias of an actor. It returns ErrNameTaken when
+// name is the name or an alias of another actor.
+func (r *ActorRepository) AddAlias(id int, name string) error {
+	return r.changeAliases(id, func(tx *sql.Tx) error {
+		return addAlias(tx, actorRelation, id, name)
+	})
+}
+
+// RemoveAlias removes an alias of an actor. It returns sql.ErrNoRows when
+// the actor has no such alias.
+func (r *ActorRepository) RemoveAlias(id int, name string) error {
+	return r.changeAliases(id, func(tx *sql.Tx) error {
+		return removeAlias(tx, actorRelation, id, name)
+	})
+}
+
+func (r *ActorRepository) changeAliases(id int, change func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := change(tx); err != nil {
+		return err
+	}
+	if err := reindexLinked(tx, actorRelation, []int{id}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
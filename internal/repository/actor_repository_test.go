@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iwaco/movies/internal/model"
+)
+
+func searchTotal(t *testing.T, repo *VideoRepository, query string) int {
+	t.Helper()
+	result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Query: query})
+	if err != nil {
+		t.Fatalf("failed to search '%s': %v", query, err)
+	}
+	return result.Total
+}
+
+func TestActorRepositoryGet(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewActorRepository(db)
+	// Actor A is in vid1 (2024-01-15, rated 4) and vid3 (2024-03-10, unrated)
+	actor, err := repo.Get(1)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if actor.Name != "Actor A" || len(actor.Aliases) != 0 {
+		t.Errorf("unexpected actor: %+v", actor)
+	}
+	stats := actor.Stats
+	if stats.VideoCount != 2 || stats.FirstDate != "2024-01-15" || stats.LastDate != "2024-03-10" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.RatedCount != 1 || stats.AverageRating == nil || *stats.AverageRating != 4 {
+		t.Errorf("expected an average of one rating of 4, got %+v", stats)
+	}
+
+	// Actor C's only video is unrated
+	actor, err = repo.Get(3)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	if actor.Stats.AverageRating != nil {
+		t.Errorf("expected no average rating, got %v", *actor.Stats.AverageRating)
+	}
+
+	if _, err := repo.Get(99); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestActorRepositoryUpdate(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+	resetUpdatedAt(t, db)
+
+	repo := NewActorRepository(db)
+	name, kana, romaji, notes := "山田花子", "やまだはなこ", "Yamada Hanako", "メモ"
+	actor, err := repo.Update(1, model.ActorUpdate{Name: &name, Kana: &kana, Romaji: &romaji, Notes: &notes})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if actor.Name != name || actor.Kana != kana || actor.Romaji != romaji || actor.Notes != notes {
+		t.Errorf("unexpected actor: %+v", actor)
+	}
+	if fmt.Sprint(actor.Aliases) != "[Actor A]" {
+		t.Errorf("expected the old name as alias, got %v", actor.Aliases)
+	}
+	// Only the name is shown on the videos
+	if touched := touchedVideos(t, db); fmt.Sprint(touched) != "[vid1 vid3]" {
+		t.Errorf("expected updated_at of Actor A's videos to advance, got %v", touched)
+	}
+	resetUpdatedAt(t, db)
+	if _, err := repo.Update(1, model.ActorUpdate{Notes: &notes}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if touched := touchedVideos(t, db); len(touched) != 0 {
+		t.Errorf("expected a profile update to leave updated_at, got %v", touched)
+	}
+
+	// The name, readings and old name all find Actor A's videos
+	videos := NewVideoRepository(db)
+	for _, query := range []string{"actor:山田花子", "actor:ヤマダ", "actor:hanako", `actor:"Actor A"`} {
+		if total := searchTotal(t, videos, query); total != 2 {
+			t.Errorf("query '%s': expected 2 results, got %d", query, total)
+		}
+	}
+
+	taken := "Actor B"
+	if _, err := repo.Update(1, model.ActorUpdate{Name: &taken}); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken, got %v", err)
+	}
+	if _, err := repo.Update(99, model.ActorUpdate{Notes: &notes}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestActorRepositoryMerge(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewActorRepository(db)
+	kana, notes := "あくたーびー", "B のメモ"
+	if _, err := repo.Update(2, model.ActorUpdate{Kana: &kana, Notes: &notes}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	own := "A のメモ"
+	if _, err := repo.Update(1, model.ActorUpdate{Notes: &own}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := repo.AddAlias(2, "B-san"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	resetUpdatedAt(t, db)
+
+	actor, err := repo.Merge(1, []int{2})
+	if err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	// Empty fields are filled from the merged actor; set ones are kept
+	if actor.Kana != kana || actor.Notes != own {
+		t.Errorf("unexpected profile after merge: %+v", actor)
+	}
+	if fmt.Sprint(actor.Aliases) != "[Actor B B-san]" {
+		t.Errorf("expected merged names as aliases, got %v", actor.Aliases)
+	}
+	if actor.Stats.VideoCount != 3 {
+		t.Errorf("expected 3 videos after merge, got %d", actor.Stats.VideoCount)
+	}
+	if touched := touchedVideos(t, db); fmt.Sprint(touched) != "[vid1 vid2]" {
+		t.Errorf("expected updated_at of Actor B's videos to advance, got %v", touched)
+	}
+
+	videos := NewVideoRepository(db)
+	if total := searchTotal(t, videos, "actor:B-san"); total != 3 {
+		t.Errorf("expected the merged alias to find all 3 videos, got %d", total)
+	}
+	if _, err := repo.Get(2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the merged actor to be deleted, got %v", err)
+	}
+}
+
+func TestActorRepositoryPruneKeepsProfiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewActorRepository(db)
+	notes := "keep"
+	if _, err := repo.Update(3, model.ActorUpdate{Notes: &notes}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	// vid2 is the only video of Actor C
+	videos := NewVideoRepository(db)
+	if err := videos.Delete("vid2"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	result, err := videos.Prune()
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if result.Actors != 0 {
+		t.Errorf("expected Actor C with a profile to be kept, pruned %d", result.Actors)
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCursor is returned by List when the cursor cannot be decoded or
+// was issued for a different sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// sortKey is one ORDER BY term of a video sort.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// ratingExpr is a video's rating, or the %d value when it is unrated.
+const ratingExpr = "COALESCE((SELECT rating FROM ratings WHERE video_id = v.id), %d)"
+
+// timestampExpr is the %s timestamp in one text format, with the
+// milliseconds that updated_at is written with.
+const timestampExpr = "strftime('%%Y-%%m-%%d %%H:%%M:%%f', %s)"
+
+// videoSortKeys returns the ORDER BY terms for sort. Every sort ends with
+// v.id so that rows are totally ordered, which keeps both offset and cursor
+// pagination stable when other keys tie. seed is only used by random.
+func videoSortKeys(sort string, ranked bool, seed int64) []sortKey {
+	var keys []sortKey
+	switch sort {
+	case "date_asc":
+		keys = []sortKey{{"v.date", false}}
+	case "title_asc":
+		keys = []sortKey{{"v.title", false}}
+	case "title_desc":
+		keys = []sortKey{{"v.title", true}}
+	case "rating_desc":
+		// Unrated videos sort after the 1-5 ratings in both directions
+		keys = []sortKey{{fmt.Sprintf(ratingExpr, 0), true}, {"v.date", true}}
+	case "rating_asc":
+		keys = []sortKey{{fmt.Sprintf(ratingExpr, 6), false}, {"v.date", true}}
+	case "created_desc":
+		keys = []sortKey{{fmt.Sprintf(timestampExpr, "v.created_at"), true}}
+	case "updated_desc":
+		keys = []sortKey{{fmt.Sprintf(timestampExpr, "v.updated_at"), true}}
+	case "random":
+		keys = []sortKey{{fmt.Sprintf("shuffle_key(%d, v.id)", seed), false}}
+	case "relevance":
+		if ranked {
+			keys = []sortKey{{"fts.rank", false}, {"v.date", true}}
+		} else {
+			keys = []sortKey{{"v.date", true}}
+		}
+	default:
+		keys = []sortKey{{"v.date", true}}
+	}
+	return append(keys, sortKey{"v.id", false})
+}
+
+func orderByClause(keys []sortKey) string {
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		terms[i] = k.expr
+		if k.desc {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// keysetCondition selects the rows that sort after the row whose key values
+// are bound to $argIdx, $argIdx+1, ... in key order.
+func keysetCondition(keys []sortKey, argIdx int) string {
+	ors := make([]string, len(keys))
+	for i, k := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = $%d", keys[j].expr, argIdx+j))
+		}
+		op := ">"
+		if k.desc {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s $%d", k.expr, op, argIdx+i))
+		ors[i] = "(" + strings.Join(ands, " AND ") + ")"
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+// videoCursor is the decoded form of the opaque cursor handed to clients:
+// the sort it was issued for, the seed of a random sort and the sort key
+// values of the last row.
+type videoCursor struct {
+	Sort   string        `json:"s"`
+	Seed   int64         `json:"r,omitempty"`
+	Values []interface{} `json:"k"`
+}
+
+func encodeCursor(c videoCursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s, sort string) (videoCursor, error) {
+	var c videoCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != sort {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/iwaco/movies/internal/database"
+)
+
+// ErrNameTaken is returned when a rename or alias would give a name that
+// already belongs to another row, either as its name or as an alias.
+var ErrNameTaken = errors.New("name is already in use")
+
+// The functions below implement renaming, merging and aliasing for any
+// relation whose names table has aliases. They run inside the caller's
+// transaction and keep videos_fts and the updated_at of the linked videos
+// in sync with the new names.
+
+// checkNameFree returns ErrNameTaken when name is the name or an alias of a
+// row other than id.
+func checkNameFree(tx *sql.Tx, rel relation, id int, name string) error {
+	owner, err := rel.names.Lookup(tx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != id {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// renameNamed renames row id to name and keeps the old name as an alias,
+// so that later imports using it resolve to the renamed row.
+func renameNamed(tx *sql.Tx, rel relation, id int, name string) error {
+	var old string
+	if err := tx.QueryRow(fmt.Sprintf("SELECT name FROM %s WHERE id = $1", rel.names.Table), id).Scan(&old); err != nil {
+		return err
+	}
+	if old == name {
+		return nil
+	}
+	if err := checkNameFree(tx, rel, id, name); err != nil {
+		return err
+	}
+
+	n := rel.names
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE name = $1", n.AliasTable), name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET name = $1 WHERE id = $2", n.Table), name, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (name, %s) VALUES ($1, $2)", n.AliasTable, n.AliasKey), old, id); err != nil {
+		return err
+	}
+	videoIDs, err := linkedVideos(tx, rel, []int{id})
+	if err != nil {
+		return err
+	}
+	return renamedVideos(tx, videoIDs)
+}
+
+// mergeNamed moves the video links and aliases of the sources rows to
+// target, deletes the sources and keeps their names as aliases of target.
+func mergeNamed(tx *sql.Tx, rel relation, target int, sources []int) error {
+	if err := checkNamedExists(tx, rel, target); err != nil {
+		return err
+	}
+	var ids []int
+	for _, id := range sources {
+		if err := checkNamedExists(tx, rel, id); err != nil {
+			return err
+		}
+		if id != target {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	videoIDs, err := linkedVideos(tx, rel, ids)
+	if err != nil {
+		return err
+	}
+	n := rel.names
+	in := "IN (SELECT value FROM json_each($2))"
+	for _, q := range []string{
+		fmt.Sprintf("INSERT OR IGNORE INTO %s (video_id, %s) SELECT video_id, $1 FROM %s WHERE %s %s",
+			rel.joinTable, rel.joinKey, rel.joinTable, rel.joinKey, in),
+		fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s %s", n.AliasTable, n.AliasKey, n.AliasKey, in),
+		fmt.Sprintf("INSERT OR REPLACE INTO %s (name, %s) SELECT name, $1 FROM %s WHERE id %s",
+			n.AliasTable, n.AliasKey, n.Table, in),
+	} {
+		if _, err := tx.Exec(q, target, string(data)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT value FROM json_each($1))", n.Table), string(data)); err != nil {
+		return err
+	}
+	return renamedVideos(tx, videoIDs)
+}
+
+// addAlias makes name resolve to row id.
+func addAlias(tx *sql.Tx, rel relation, id int, name string) error {
+	if err := checkNamedExists(tx, rel, id); err != nil {
+		return err
+	}
+	owner, err := rel.names.Lookup(tx, name)
+	if err == nil {
+		// Already resolves to id, as its name or an alias
+		if owner != id {
+			return ErrNameTaken
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (name, %s) VALUES ($1, $2)", rel.names.AliasTable, rel.names.AliasKey), name, id)
+	return err
+}
+
+// removeAlias deletes an alias of row id. It returns sql.ErrNoRows when id
+// has no such alias.
+func removeAlias(tx *sql.Tx, rel relation, id int, name string) error {
+	n := rel.names
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE name = $1 AND %s = $2", n.AliasTable, n.AliasKey), name, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// listAliases returns the aliases of row id. It returns sql.ErrNoRows when
+// the row does not exist.
+func listAliases(db *sql.DB, rel relation, id int) ([]string, error) {
+	if err := checkNamedExists(db, rel, id); err != nil {
+		return nil, err
+	}
+	n := rel.names
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM %s WHERE %s = $1 ORDER BY name", n.AliasTable, n.AliasKey), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, name)
+	}
+	return aliases, rows.Err()
+}
+
+// linkedVideos returns the IDs of the videos linked to any of the rows ids.
+func linkedVideos(tx *sql.Tx, rel relation, ids []int) ([]string, error) {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(fmt.Sprintf("SELECT DISTINCT video_id FROM %s WHERE %s IN (SELECT value FROM json_each($1))",
+		rel.joinTable, rel.joinKey), string(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var videoIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		videoIDs = append(videoIDs, id)
+	}
+	return videoIDs, rows.Err()
+}
+
+// reindexLinked rebuilds the videos_fts rows of the videos linked to ids.
+func reindexLinked(tx *sql.Tx, rel relation, ids []int) error {
+	videoIDs, err := linkedVideos(tx, rel, ids)
+	if err != nil {
+		return err
+	}
+	return indexVideos(tx, videoIDs)
+}
+
+// renamedVideos reindexes the videos videoIDs and advances their
+// updated_at, as the names they show have changed.
+func renamedVideos(tx *sql.Tx, videoIDs []string) error {
+	data, err := json.Marshal(videoIDs)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE videos SET updated_at = "+database.TouchUpdatedAt+
+		" WHERE id IN (SELECT value FROM json_each($1))", string(data)); err != nil {
+		return err
+	}
+	return indexVideos(tx, videoIDs)
+}
+
+func indexVideos(tx *sql.Tx, videoIDs []string) error {
+	for _, id := range videoIDs {
+		if err := database.IndexVideo(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNamedExists returns sql.ErrNoRows when row id does not exist.
+func checkNamedExists(q database.Querier, rel relation, id int) error {
+	var exists int
+	return q.QueryRow(fmt.Sprintf("SELECT 1 FROM %s WHERE id = $1", rel.names.Table), id).Scan(&exists)
+}
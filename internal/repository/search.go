@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/iwaco/movies/internal/textnorm"
+)
+
+// searchColumns maps the column prefixes accepted in a search query
+// (title:foo, actor:"Name", tag:bar) to videos_fts columns.
+var searchColumns = map[string]string{
+	"title":  "title",
+	"actor":  "actors",
+	"actors": "actors",
+	"tag":    "tags",
+	"tags":   "tags",
+}
+
+// ftsAllColumns restricts unscoped terms to the searchable columns.
+const ftsAllColumns = "{title actors tags}"
+
+// ftsRank orders matches by bm25 with title hits weighted above actor and
+// tag hits. The video_id column gets no weight.
+const ftsRank = "bm25(videos_fts, 0.0, 10.0, 5.0, 3.0)"
+
+// minTrigramLen is the shortest term the trigram tokenizer can look up.
+const minTrigramLen = 3
+
+type searchTerm struct {
+	column string
+	text   string
+	prefix bool
+}
+
+func (t searchTerm) short() bool {
+	return utf8.RuneCountInString(t.text) < minTrigramLen
+}
+
+// globCondition returns a condition on videos_fts matching the term with
+// GLOB, taking globPattern as argument n. The columns were normalized like
+// the term when they were indexed, which makes the case-sensitive GLOB
+// match any case, and get a space at both ends so that a pattern can
+// anchor at the start or end of a word.
+func (t searchTerm) globCondition(n int) string {
+	columns := []string{"title", "actors", "tags"}
+	if t.column != "" {
+		columns = []string{t.column}
+	}
+	var conds []string
+	for _, column := range columns {
+		conds = append(conds, fmt.Sprintf("' ' || %s || ' ' GLOB $%d", column, n))
+	}
+	return strings.Join(conds, " OR ")
+}
+
+// wordBoundary matches a character that cannot be part of a word in
+// normalized text, which has no upper case letters.
+const wordBoundary = "[^0-9a-z]"
+
+// globPattern returns the GLOB pattern of the term. A prefix term matches
+// at the start of a word. A short term matches a whole word, as with a
+// word tokenizer, unless it is Japanese, whose words are not separated by
+// spaces and which matches as a substring. Words end at any character but
+// ASCII letters and digits, so OL is a word in 美人OLの休日.
+func (t searchTerm) globPattern() string {
+	text := escapeGlob(t.text)
+	switch {
+	case t.prefix:
+		return "*" + wordBoundary + text + "*"
+	case !containsCJK(t.text):
+		return "*" + wordBoundary + text + wordBoundary + "*"
+	}
+	return "*" + text + "*"
+}
+
+func escapeGlob(s string) string {
+	r := strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+	return r.Replace(s)
+}
+
+// searchQuery is a parsed user search query. Terms long enough for the
+// trigram index are combined into match. Shorter terms, which the index
+// cannot look up, and prefix terms, which it matches anywhere in a word,
+// are kept in globs and matched with GLOB against videos_fts instead or as
+// well.
+type searchQuery struct {
+	match string
+	globs []searchTerm
+}
+
+func (q searchQuery) empty() bool {
+	return q.match == "" && len(q.globs) == 0
+}
+
+// parseSearchQuery normalizes q and splits it into whitespace-separated
+// terms. A term may be a "quoted phrase", end in * for a prefix match, and
+// be scoped to a column with title:, actor: or tag:. All terms must match,
+// each as a substring, or as globPattern describes for prefix and short
+// terms.
+func parseSearchQuery(q string) searchQuery {
+	var result searchQuery
+	var match []string
+	for _, term := range splitSearchTerms(textnorm.Normalize(q)) {
+		if !hasSearchableRune(term.text) {
+			continue
+		}
+		if term.short() || term.prefix {
+			result.globs = append(result.globs, term)
+		}
+		if term.short() {
+			continue
+		}
+		column := ftsAllColumns
+		if term.column != "" {
+			column = term.column
+		}
+		match = append(match, column+` : "`+strings.ReplaceAll(term.text, `"`, `""`)+`"`)
+	}
+	result.match = strings.Join(match, " AND ")
+	return result
+}
+
+func splitSearchTerms(q string) []searchTerm {
+	var terms []searchTerm
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var term searchTerm
+		if j := indexRune(runes[i:], ':'); j > 0 {
+			if column, ok := searchColumns[string(runes[i:i+j])]; ok {
+				term.column = column
+				i += j + 1
+			}
+		}
+
+		var text []rune
+		if i < len(runes) && runes[i] == '"' {
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				text = append(text, runes[i])
+				i++
+			}
+			i++
+		} else {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				text = append(text, runes[i])
+				i++
+			}
+		}
+		if n := len(text); n > 0 && text[n-1] == '*' {
+			text = text[:n-1]
+			term.prefix = true
+		} else if i < len(runes) && runes[i] == '*' {
+			term.prefix = true
+			i++
+		}
+
+		term.text = string(text)
+		if term.text != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// indexRune returns the index of r in the leading non-space run of s, or -1.
+func indexRune(s []rune, r rune) int {
+	for i, c := range s {
+		if unicode.IsSpace(c) || c == '"' {
+			return -1
+		}
+		if c == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasSearchableRune(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCJK(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) {
+			return true
+		}
+	}
+	return false
+}
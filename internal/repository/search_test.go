@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantMatch string
+		wantGlobs []searchTerm
+	}{
+		{"単語", "foo", `{title actors tags} : "foo"`, nil},
+		{"複数語", "foo bar", `{title actors tags} : "foo" AND {title actors tags} : "bar"`, nil},
+		{"前方一致", "foo*", `{title actors tags} : "foo"`, []searchTerm{{text: "foo", prefix: true}}},
+		{"フレーズ", `"foo bar"`, `{title actors tags} : "foo bar"`, nil},
+		{"フレーズの前方一致", `"foo bar"*`, `{title actors tags} : "foo bar"`, []searchTerm{{text: "foo bar", prefix: true}}},
+		{"列指定", "title:foo", `title : "foo"`, nil},
+		{"列指定のエイリアス", `actor:"Actor A" tag:xyz`, `actors : "actor a" AND tags : "xyz"`, nil},
+		{"未知の列名は語の一部", "foo:bar", `{title actors tags} : "foo:bar"`, nil},
+		{"引用符のエスケープ", `a"bc`, `{title actors tags} : "a""bc"`, nil},
+		{"記号のみの語は無視", "% _", "", nil},
+		{"短い語は別の条件", "Actor A", `{title actors tags} : "actor"`, []searchTerm{{text: "a"}}},
+		{"短い語の列指定", "title:foo tag:x", `title : "foo"`, []searchTerm{{column: "tags", text: "x"}}},
+		{"短い語の前方一致", "tag:x*", "", []searchTerm{{column: "tags", text: "x", prefix: true}}},
+		{"短い語だけならGLOB", "title:秘密", "", []searchTerm{{column: "title", text: "秘密"}}},
+		{"正規化", "ＴＥＳＴ てすと", `{title actors tags} : "test" AND {title actors tags} : "テスト"`, nil},
+		{"全角の列指定", "ｔｉｔｌｅ：ｆｏｏ", `title : "foo"`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseSearchQuery(tt.query)
+			if got.match != tt.wantMatch {
+				t.Errorf("match = %q, want %q", got.match, tt.wantMatch)
+			}
+			if !reflect.DeepEqual(got.globs, tt.wantGlobs) {
+				t.Errorf("globs = %+v, want %+v", got.globs, tt.wantGlobs)
+			}
+		})
+	}
+}
+
+func TestSearchTermGlobPattern(t *testing.T) {
+	tests := []struct {
+		name string
+		term searchTerm
+		want string
+	}{
+		{"短い語は語全体", searchTerm{text: "a"}, "*[^0-9a-z]a[^0-9a-z]*"},
+		{"前方一致は語頭", searchTerm{text: "foo", prefix: true}, "*[^0-9a-z]foo*"},
+		{"日本語は部分一致", searchTerm{text: "休日"}, "*休日*"},
+		{"GLOBの記号はエスケープ", searchTerm{text: "a*", prefix: true}, "*[^0-9a-z]a[*]*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.term.globPattern(); got != tt.want {
+				t.Errorf("globPattern() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
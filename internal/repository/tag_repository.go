@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/iwaco/movies/internal/model"
+)
+
+// TagRepository manages tags themselves: renaming, merging and aliases.
+// An alias is another name for a tag; importing or assigning a video the
+// alias links the video to the tag instead of creating a new one.
+type TagRepository struct {
+	db *sql.DB
+}
+
+func NewTagRepository(db *sql.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+func (r *TagRepository) Get(id int) (*model.Tag, error) {
+	var t model.Tag
+	if err := r.db.QueryRow("SELECT id, name FROM tags WHERE id = $1", id).Scan(&t.ID, &t.Name); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Rename renames a tag and keeps its old name as an alias. It returns
+// sql.ErrNoRows when the tag does not exist and ErrNameTaken when name
+// belongs to another tag.
+func (r *TagRepository) Rename(id int, name string) (*model.Tag, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := renameNamed(tx, tagRelation, id, name); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(id)
+}
+
+// Merge merges the tags sources into target: their videos are tagged with
+// target instead and their names and aliases become aliases of target. It
+// returns sql.ErrNoRows when any of the tags does not exist.
+func (r *TagRepository) Merge(target int, sources []int) (*model.Tag, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := mergeNamed(tx, tagRelation, target, sources); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(target)
+}
+
+// Aliases returns the aliases of a tag.
+func (r *TagRepository) Aliases(id int) ([]string, error) {
+	return listAliases(r.db, tagRelation, id)
+}
+
+// AddAlias makes name an alias of a tag. It returns ErrNameTaken when name
+// is the name or an alias of another tag.
+func (r *TagRepository) AddAlias(id int, name string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addAlias(tx, tagRelation, id, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveAlias removes an alias of a tag. It returns sql.ErrNoRows when the
+// tag has no such alias.
+func (r *TagRepository) RemoveAlias(id int, name string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := removeAlias(tx, tagRelation, id, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
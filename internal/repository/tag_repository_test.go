@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/model"
+)
+
+func tagNames(t *testing.T, repo *VideoRepository, id string) []string {
+	t.Helper()
+	video, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", id, err)
+	}
+	var names []string
+	for _, tag := range video.Tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+// resetUpdatedAt sets updated_at of every video to a time in the past.
+func resetUpdatedAt(t *testing.T, db *database.DB) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE videos SET updated_at = '2000-01-01 00:00:00'"); err != nil {
+		t.Fatalf("failed to reset updated_at: %v", err)
+	}
+}
+
+// touchedVideos returns the IDs of the videos written since resetUpdatedAt.
+func touchedVideos(t *testing.T, db *database.DB) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT id FROM videos WHERE updated_at != '2000-01-01 00:00:00' ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query updated_at: %v", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestTagRepositoryRename(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+	resetUpdatedAt(t, db)
+
+	repo := NewTagRepository(db)
+	tag, err := repo.Rename(1, "タグ一")
+	if err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if tag.Name != "タグ一" {
+		t.Errorf("expected renamed tag, got %s", tag.Name)
+	}
+
+	aliases, err := repo.Aliases(1)
+	if err != nil {
+		t.Fatalf("failed to list aliases: %v", err)
+	}
+	if fmt.Sprint(aliases) != "[tag1]" {
+		t.Errorf("expected the old name as alias, got %v", aliases)
+	}
+	if touched := touchedVideos(t, db); fmt.Sprint(touched) != "[vid1]" {
+		t.Errorf("expected updated_at of vid1 to advance, got %v", touched)
+	}
+
+	// videos_fts has the new name
+	videos := NewVideoRepository(db)
+	result, err := videos.List(model.VideoQueryParams{Page: 1, PerPage: 20, Query: "tag:タグ一"})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if result.Total != 1 || result.Data[0].ID != "vid1" {
+		t.Errorf("expected vid1 to match the new name, got %d results", result.Total)
+	}
+
+	// Renaming back takes the name over from the alias
+	if _, err := repo.Rename(1, "tag1"); err != nil {
+		t.Fatalf("failed to rename back: %v", err)
+	}
+	aliases, _ = repo.Aliases(1)
+	if fmt.Sprint(aliases) != "[タグ一]" {
+		t.Errorf("expected aliases [タグ一], got %v", aliases)
+	}
+
+	if _, err := repo.Rename(1, "tag2"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken, got %v", err)
+	}
+	if _, err := repo.Rename(99, "x"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTagRepositoryMerge(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewTagRepository(db)
+	if err := repo.AddAlias(3, "Tag3"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	resetUpdatedAt(t, db)
+
+	// vid1 has tag1 and tag2, vid2 tag2 and tag3, vid3 tag3
+	tag, err := repo.Merge(2, []int{1, 3, 2})
+	if err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	if tag.Name != "tag2" {
+		t.Errorf("expected target tag2, got %s", tag.Name)
+	}
+
+	videos := NewVideoRepository(db)
+	for _, id := range []string{"vid1", "vid2", "vid3"} {
+		if names := tagNames(t, videos, id); fmt.Sprint(names) != "[tag2]" {
+			t.Errorf("%s: expected [tag2], got %v", id, names)
+		}
+	}
+	tags, err := videos.ListTags(model.NameQueryParams{})
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if len(tags.Tags) != 1 || tags.Tags[0].VideoCount != 3 {
+		t.Errorf("expected merged tags to be deleted, got %v", tags.Tags)
+	}
+	aliases, err := repo.Aliases(2)
+	if err != nil {
+		t.Fatalf("failed to list aliases: %v", err)
+	}
+	if fmt.Sprint(aliases) != "[Tag3 tag1 tag3]" {
+		t.Errorf("expected merged names as aliases, got %v", aliases)
+	}
+	if touched := touchedVideos(t, db); fmt.Sprint(touched) != "[vid1 vid2 vid3]" {
+		t.Errorf("expected updated_at of the merged tags' videos to advance, got %v", touched)
+	}
+
+	result, err := videos.List(model.VideoQueryParams{Page: 1, PerPage: 20, Query: "tag:tag3"})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if result.Total != 0 {
+		t.Errorf("expected videos_fts to drop merged names, got %d results", result.Total)
+	}
+
+	if _, err := repo.Merge(2, []int{99}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTagRepositoryAliases(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewTagRepository(db)
+	if err := repo.AddAlias(1, "Tag1"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	// Adding it again, or the tag's own name, changes nothing
+	if err := repo.AddAlias(1, "Tag1"); err != nil {
+		t.Errorf("expected re-adding an alias to succeed, got %v", err)
+	}
+	if err := repo.AddAlias(1, "tag1"); err != nil {
+		t.Errorf("expected adding the tag's own name to succeed, got %v", err)
+	}
+	if err := repo.AddAlias(2, "Tag1"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken for another tag's alias, got %v", err)
+	}
+	if err := repo.AddAlias(2, "tag3"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken for another tag's name, got %v", err)
+	}
+	if err := repo.AddAlias(99, "x"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	// PATCH resolves aliases like the importer does
+	videos := NewVideoRepository(db)
+	if _, err := videos.Update("vid3", model.VideoUpdate{AddTags: []string{"Tag1"}}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if names := tagNames(t, videos, "vid3"); fmt.Sprint(names) != "[tag1 tag3]" {
+		t.Errorf("expected the alias to resolve to tag1, got %v", names)
+	}
+
+	// Aliases match their case and width variants, like search does
+	if err := repo.AddAlias(2, "タグ2"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	if err := repo.AddAlias(3, "たぐ２"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken for a variant of another tag's alias, got %v", err)
+	}
+	if _, err := videos.Update("vid3", model.VideoUpdate{RemoveTags: []string{"TAG1"}, AddTags: []string{"たぐ２"}}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if names := tagNames(t, videos, "vid3"); fmt.Sprint(names) != "[tag2 tag3]" {
+		t.Errorf("expected the variants to resolve to tag1 and tag2, got %v", names)
+	}
+	if err := repo.RemoveAlias(2, "タグ2"); err != nil {
+		t.Fatalf("failed to remove alias: %v", err)
+	}
+
+	if err := repo.RemoveAlias(1, "Tag1"); err != nil {
+		t.Fatalf("failed to remove alias: %v", err)
+	}
+	if err := repo.RemoveAlias(1, "Tag1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+	aliases, err := repo.Aliases(1)
+	if err != nil {
+		t.Fatalf("failed to list aliases: %v", err)
+	}
+	if len(aliases) != 0 {
+		t.Errorf("expected no aliases, got %v", aliases)
+	}
+}
@@ -2,47 +2,122 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/iwaco/movies/internal/database"
 	"github.com/iwaco/movies/internal/model"
+	"github.com/iwaco/movies/internal/textnorm"
 )
 
+// ErrConflict is returned by Update when the video was changed after the
+// version the caller based its update on.
+var ErrConflict = errors.New("video was modified concurrently")
+
 type VideoRepository struct {
 	db *sql.DB
+	// q runs the reads: db, or the transaction of a Snapshot.
+	q querier
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
 func NewVideoRepository(db *sql.DB) *VideoRepository {
-	return &VideoRepository{db: db}
+	return &VideoRepository{db: db, q: db}
 }
 
-func escapeLike(s string) string {
-	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
-	return r.Replace(s)
+// placeholders returns n comma-separated parameters numbered from start.
+func placeholders(start, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(ps, ", ")
 }
 
-func (r *VideoRepository) List(params model.VideoQueryParams) (*model.VideoListResult, error) {
-	where := []string{"1=1"}
+func appendStrings(args []interface{}, values []string) []interface{} {
+	for _, v := range values {
+		args = append(args, v)
+	}
+	return args
+}
+
+// videoFilter is the FROM and WHERE part of a video query built from
+// VideoQueryParams. Further arguments must be numbered from len(args)+1.
+type videoFilter struct {
+	from   string
+	where  string
+	args   []interface{}
+	ranked bool
+}
+
+func buildVideoFilter(params model.VideoQueryParams) videoFilter {
+	where := []string{"v.deleted_at IS NULL"}
 	args := []interface{}{}
 	argIdx := 1
 
+	// Full-text search goes through videos_fts; ranked is set when bm25
+	// scores are available for sort=relevance.
+	from := "videos v"
+	ranked := false
 	if params.Query != "" {
-		escaped := "%" + escapeLike(params.Query) + "%"
-		where = append(where, fmt.Sprintf(
-			"(v.title LIKE $%d ESCAPE '\\' OR v.id IN (SELECT va.video_id FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE a.name LIKE $%d ESCAPE '\\') OR v.id IN (SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name LIKE $%d ESCAPE '\\'))",
-			argIdx, argIdx+1, argIdx+2))
-		args = append(args, escaped, escaped, escaped)
-		argIdx += 3
-	}
-	for _, tag := range params.Tags {
-		where = append(where, fmt.Sprintf("v.id IN (SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name = $%d)", argIdx))
-		args = append(args, tag)
-		argIdx++
+		sq := parseSearchQuery(params.Query)
+		if sq.empty() {
+			where = append(where, "0")
+		}
+		if sq.match != "" {
+			from += fmt.Sprintf(" JOIN (SELECT video_id, %s AS rank FROM videos_fts WHERE videos_fts MATCH $%d) fts ON fts.video_id = v.id", ftsRank, argIdx)
+			args = append(args, sq.match)
+			argIdx++
+			ranked = true
+		}
+		for _, term := range sq.globs {
+			where = append(where, fmt.Sprintf("v.id IN (SELECT video_id FROM videos_fts WHERE %s)", term.globCondition(argIdx)))
+			args = append(args, term.globPattern())
+			argIdx++
+		}
 	}
-	for _, actor := range params.Actors {
-		where = append(where, fmt.Sprintf("v.id IN (SELECT va.video_id FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE a.name = $%d)", argIdx))
-		args = append(args, actor)
-		argIdx++
+	tagIn := "v.id IN (SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name IN (%s))"
+	actorIn := "v.id IN (SELECT va.video_id FROM video_actors va JOIN actors a ON a.id = va.actor_id WHERE a.name IN (%s))"
+	if params.TagMode == "any" && len(params.Tags) > 0 {
+		where = append(where, fmt.Sprintf(tagIn, placeholders(argIdx, len(params.Tags))))
+		args = appendStrings(args, params.Tags)
+		argIdx += len(params.Tags)
+	} else {
+		for _, tag := range params.Tags {
+			where = append(where, fmt.Sprintf(tagIn, fmt.Sprintf("$%d", argIdx)))
+			args = append(args, tag)
+			argIdx++
+		}
+	}
+	if len(params.ExcludeTags) > 0 {
+		where = append(where, "NOT "+fmt.Sprintf(tagIn, placeholders(argIdx, len(params.ExcludeTags))))
+		args = appendStrings(args, params.ExcludeTags)
+		argIdx += len(params.ExcludeTags)
+	}
+	if params.ActorMode == "any" && len(params.Actors) > 0 {
+		where = append(where, fmt.Sprintf(actorIn, placeholders(argIdx, len(params.Actors))))
+		args = appendStrings(args, params.Actors)
+		argIdx += len(params.Actors)
+	} else {
+		for _, actor := range params.Actors {
+			where = append(where, fmt.Sprintf(actorIn, fmt.Sprintf("$%d", argIdx)))
+			args = append(args, actor)
+			argIdx++
+		}
+	}
+	if len(params.ExcludeActors) > 0 {
+		where = append(where, "NOT "+fmt.Sprintf(actorIn, placeholders(argIdx, len(params.ExcludeActors))))
+		args = appendStrings(args, params.ExcludeActors)
+		argIdx += len(params.ExcludeActors)
 	}
 	if params.DateFrom != "" {
 		where = append(where, fmt.Sprintf("v.date >= $%d", argIdx))
@@ -63,24 +138,51 @@ func (r *VideoRepository) List(params model.VideoQueryParams) (*model.VideoListR
 		where = append(where, "EXISTS (SELECT 1 FROM video_formats vf WHERE vf.video_id = v.id)")
 	}
 
-	whereClause := strings.Join(where, " AND ")
+	return videoFilter{
+		from:   from,
+		where:  strings.Join(where, " AND "),
+		args:   args,
+		ranked: ranked,
+	}
+}
+
+// List returns a page of videos matching params. Pages are addressed by
+// params.Page, or by params.Cursor when it is set, in which case the page
+// continues after the row the cursor was issued for and Page is ignored.
+// NextCursor is set whenever more rows follow the returned page.
+func (r *VideoRepository) List(params model.VideoQueryParams) (*model.VideoListResult, error) {
+	f := buildVideoFilter(params)
+	args := f.args
+	argIdx := len(args) + 1
 
 	// Count total
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM videos v WHERE %s", whereClause)
 	var total int
-	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
-		return nil, err
+	if !params.SkipTotal {
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", f.from, f.where)
+		if err := r.q.QueryRow(countQuery, args...).Scan(&total); err != nil {
+			return nil, err
+		}
 	}
 
-	// Sort
-	orderBy := "v.date DESC"
-	switch params.Sort {
-	case "date_asc":
-		orderBy = "v.date ASC"
-	case "title_asc":
-		orderBy = "v.title ASC"
-	case "title_desc":
-		orderBy = "v.title DESC"
+	// Sort. A random sort continues with the seed of its cursor.
+	var cursor videoCursor
+	seed := params.Seed
+	if params.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(params.Cursor, params.Sort); err != nil {
+			return nil, err
+		}
+		seed = cursor.Seed
+	}
+	keys := videoSortKeys(params.Sort, f.ranked, seed)
+	where := f.where
+	if params.Cursor != "" {
+		if len(cursor.Values) != len(keys) {
+			return nil, ErrInvalidCursor
+		}
+		where += " AND " + keysetCondition(keys, argIdx)
+		args = append(args, cursor.Values...)
+		argIdx += len(keys)
 	}
 
 	// Pagination
@@ -93,112 +195,539 @@ func (r *VideoRepository) List(params model.VideoQueryParams) (*model.VideoListR
 		perPage = 20
 	}
 	offset := (page - 1) * perPage
+	if params.Cursor != "" {
+		offset = 0
+	}
 	totalPages := (total + perPage - 1) / perPage
 	if totalPages < 1 {
 		totalPages = 1
 	}
 
-	query := fmt.Sprintf(`SELECT v.id, v.title, v.url, v.date, v.jpg, v.pictures_dir, v.created_at, v.updated_at
-		FROM videos v WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`, whereClause, orderBy, argIdx, argIdx+1)
-	args = append(args, perPage, offset)
+	// One extra row tells whether a next page exists
+	sortExprs := make([]string, len(keys))
+	for i, k := range keys {
+		sortExprs[i] = k.expr
+	}
+	query := fmt.Sprintf(`SELECT v.id, v.title, v.url, v.date, v.jpg, v.pictures_dir, v.source, v.created_at, v.updated_at, %s
+		FROM %s WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		strings.Join(sortExprs, ", "), f.from, where, orderByClause(keys), argIdx, argIdx+1)
+	args = append(args, perPage+1, offset)
 
-	rows, err := r.db.Query(query, args...)
+	rows, err := r.q.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var videos []model.Video
+	var keyValues [][]interface{}
 	for rows.Next() {
 		var v model.Video
-		if err := rows.Scan(&v.ID, &v.Title, &v.URL, &v.Date, &v.JPG, &v.PicturesDir, &v.CreatedAt, &v.UpdatedAt); err != nil {
+		values := make([]interface{}, len(keys))
+		dest := []interface{}{&v.ID, &v.Title, &v.URL, &v.Date, &v.JPG, &v.PicturesDir, &v.Source, &v.CreatedAt, &v.UpdatedAt}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, err
 		}
 		videos = append(videos, v)
+		keyValues = append(keyValues, values)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	more := len(videos) > perPage
+	if more {
+		videos = videos[:perPage]
+	}
 
-	// Load actors, tags, formats, rating for each video
-	for i := range videos {
-		if err := r.loadRelations(&videos[i]); err != nil {
+	// Load actors, tags, formats, rating for the whole page
+	if err := r.loadRelations(videos); err != nil {
+		return nil, err
+	}
+
+	result := &model.VideoListResult{
+		Data:         videos,
+		Total:        total,
+		Page:         page,
+		PerPage:      perPage,
+		TotalPages:   totalPages,
+		TotalSkipped: params.SkipTotal,
+	}
+	if params.Sort == "random" {
+		result.Seed = seed
+	}
+	if more {
+		result.NextCursor, err = encodeCursor(videoCursor{Sort: params.Sort, Seed: result.Seed, Values: keyValues[perPage-1]})
+		if err != nil {
 			return nil, err
 		}
 	}
+	return result, nil
+}
+
+// Snapshot calls fn with a repository whose reads all see the videos as
+// they were when the first of them ran, so that results read with several
+// queries are consistent. The repository passed to fn only reads.
+func (r *VideoRepository) Snapshot(fn func(*VideoRepository) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(&VideoRepository{q: tx})
+}
 
-	return &model.VideoListResult{
-		Data:       videos,
-		Total:      total,
-		Page:       page,
-		PerPage:    perPage,
-		TotalPages: totalPages,
-	}, nil
+// Each calls fn with every video matching params in their sort order,
+// reading them in pages of batch videos with a cursor. Pagination in params
+// is ignored. It stops at the first error fn returns.
+func (r *VideoRepository) Each(params model.VideoQueryParams, batch int, fn func(model.Video) error) error {
+	params.Page, params.PerPage, params.Cursor, params.SkipTotal = 1, batch, "", true
+	for {
+		result, err := r.List(params)
+		if err != nil {
+			return err
+		}
+		for _, v := range result.Data {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		if result.NextCursor == "" {
+			return nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// facetQueries count matching videos per facet value. The %s is replaced
+// with a subquery selecting the IDs of the matching videos.
+var facetQueries = map[string]string{
+	"tag": `SELECT t.name, COUNT(*) FROM video_tags vt JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_id IN (%s) GROUP BY t.id ORDER BY COUNT(*) DESC, t.name`,
+	"actor": `SELECT a.name, COUNT(*) FROM video_actors va JOIN actors a ON a.id = va.actor_id
+		WHERE va.video_id IN (%s) GROUP BY a.id ORDER BY COUNT(*) DESC, a.name`,
+	"year": `SELECT substr(date, 1, 4) AS year, COUNT(*) FROM videos
+		WHERE id IN (%s) AND date != '' GROUP BY year ORDER BY year DESC`,
+	"rating": `SELECT rating, COUNT(*) FROM ratings
+		WHERE video_id IN (%s) GROUP BY rating ORDER BY rating DESC`,
+	"format": `SELECT name, COUNT(*) FROM video_formats
+		WHERE video_id IN (%s) GROUP BY name ORDER BY COUNT(*) DESC, name`,
+}
+
+// Facets counts the videos matching params per tag, actor, year, rating and
+// format. names selects which facets to count; all are counted when empty.
+// Pagination and sort in params are ignored.
+func (r *VideoRepository) Facets(params model.VideoQueryParams, names []string) (*model.VideoFacets, error) {
+	if len(names) == 0 {
+		names = model.FacetNames
+	}
+
+	f := buildVideoFilter(params)
+	matching := fmt.Sprintf("SELECT v.id FROM %s WHERE %s", f.from, f.where)
+
+	result := &model.VideoFacets{Facets: map[string][]model.FacetCount{}}
+	if err := r.q.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s)", matching), f.args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		query, ok := facetQueries[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown facet %q", name)
+		}
+		counts, err := r.facetCounts(fmt.Sprintf(query, matching), f.args)
+		if err != nil {
+			return nil, err
+		}
+		result.Facets[name] = counts
+	}
+	return result, nil
+}
+
+func (r *VideoRepository) facetCounts(query string, args []interface{}) ([]model.FacetCount, error) {
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []model.FacetCount{}
+	for rows.Next() {
+		var c model.FacetCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
 }
 
 func (r *VideoRepository) GetByID(id string) (*model.Video, error) {
 	var v model.Video
-	err := r.db.QueryRow(`SELECT id, title, url, date, jpg, pictures_dir, created_at, updated_at FROM videos WHERE id = $1`, id).
-		Scan(&v.ID, &v.Title, &v.URL, &v.Date, &v.JPG, &v.PicturesDir, &v.CreatedAt, &v.UpdatedAt)
+	err := r.q.QueryRow(`SELECT id, title, url, date, jpg, pictures_dir, source, created_at, updated_at FROM videos WHERE id = $1 AND deleted_at IS NULL`, id).
+		Scan(&v.ID, &v.Title, &v.URL, &v.Date, &v.JPG, &v.PicturesDir, &v.Source, &v.CreatedAt, &v.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
-	if err := r.loadRelations(&v); err != nil {
+	videos := []model.Video{v}
+	if err := r.loadRelations(videos); err != nil {
 		return nil, err
 	}
-	return &v, nil
+	return &videos[0], nil
 }
 
-func (r *VideoRepository) ListTags() ([]model.Tag, error) {
-	rows, err := r.db.Query("SELECT id, name FROM tags ORDER BY name")
+// Update applies a partial update to a video, keeps videos_fts in sync and
+// returns the updated video. It returns sql.ErrNoRows when the video does
+// not exist and ErrConflict when u.UpdatedAt is set and no longer matches.
+func (r *VideoRepository) Update(id string, u model.VideoUpdate) (*model.Video, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
+
+	var updatedAt time.Time
+	if err := tx.QueryRow("SELECT updated_at FROM videos WHERE id = $1 AND deleted_at IS NULL", id).Scan(&updatedAt); err != nil {
+		return nil, err
+	}
+	if u.UpdatedAt != nil && !u.UpdatedAt.Equal(updatedAt) {
+		return nil, ErrConflict
+	}
+
+	sets := []string{}
+	args := []interface{}{id}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"title", u.Title},
+		{"url", u.URL},
+		{"date", u.Date},
+		{"jpg", u.JPG},
+		{"pictures_dir", u.PicturesDir},
+	} {
+		if field.value != nil {
+			args = append(args, *field.value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", field.column, len(args)))
+		}
+	}
+	// Clearing content_hash makes the next import compare the record with
+	// the edited video.
+	sets = append(sets, "updated_at = "+database.TouchUpdatedAt, "content_hash = ''")
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE videos SET %s WHERE id = $1", strings.Join(sets, ", ")), args...); err != nil {
+		return nil, err
+	}
+
+	if err := updateRelations(tx, id, actorRelation, u.Actors, u.AddActors, u.RemoveActors); err != nil {
+		return nil, err
+	}
+	if err := updateRelations(tx, id, tagRelation, u.Tags, u.AddTags, u.RemoveTags); err != nil {
+		return nil, err
+	}
+
+	if err := database.IndexVideo(tx, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetByID(id)
+}
+
+// relation describes a many-to-many link from videos to named rows.
+type relation struct {
+	names     database.NameTable
+	joinTable string
+	joinKey   string
+	// searchColumns are other columns of names.Table that name searches
+	// match besides the name.
+	searchColumns []string
+}
 
-	var tags []model.Tag
+var (
+	actorRelation = relation{names: database.Actors, joinTable: "video_actors", joinKey: "actor_id", searchColumns: []string{"kana", "romaji"}}
+	tagRelation   = relation{names: database.Tags, joinTable: "video_tags", joinKey: "tag_id"}
+)
+
+// updateRelations replaces the names linked to a video when replace is
+// non-nil, then links the names in add and unlinks the names in remove.
+// Names are resolved through aliases and missing rows are created.
+func updateRelations(tx *sql.Tx, videoID string, rel relation, replace *[]string, add, remove []string) error {
+	if replace != nil {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE video_id = $1", rel.joinTable), videoID); err != nil {
+			return err
+		}
+		add = slices.Concat(*replace, add)
+	}
+	for _, name := range add {
+		id, err := rel.names.Resolve(tx, name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("INSERT OR IGNORE INTO %s (video_id, %s) VALUES ($1, $2)", rel.joinTable, rel.joinKey), videoID, id)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range remove {
+		id, err := rel.names.Lookup(tx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE video_id = $1 AND %s = $2", rel.joinTable, rel.joinKey), videoID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *VideoRepository) Delete(id string) error {
+	n, err := r.DeleteMany([]string{id})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteMany deletes the videos with the given IDs and returns how many
+// existed.
+func (r *VideoRepository) DeleteMany(ids []string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := deleteVideos(tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// DeleteMatching deletes every video matching the filters in params and
+// returns how many were deleted. Paging and sort are ignored.
+func (r *VideoRepository) DeleteMatching(params model.VideoQueryParams) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The IDs are collected first since a search filter reads videos_fts,
+	// which is deleted from along with the videos.
+	f := buildVideoFilter(params)
+	rows, err := tx.Query(fmt.Sprintf("SELECT v.id FROM %s WHERE %s", f.from, f.where), f.args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
 	for rows.Next() {
-		var t model.Tag
-		if err := rows.Scan(&t.ID, &t.Name); err != nil {
-			return nil, err
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
 		}
-		tags = append(tags, t)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := deleteVideos(tx, ids)
+	if err != nil {
+		return 0, err
 	}
-	return tags, rows.Err()
+	return n, tx.Commit()
 }
 
-func (r *VideoRepository) ListActors() ([]model.Actor, error) {
-	rows, err := r.db.Query("SELECT id, name FROM actors ORDER BY name")
+// deleteVideos deletes videos and their videos_fts rows, which have no
+// foreign key to cascade from.
+func deleteVideos(tx *sql.Tx, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM videos_fts WHERE video_id IN (SELECT value FROM json_each($1))", string(data)); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM videos WHERE id IN (SELECT value FROM json_each($1))", string(data))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Prune removes actors and tags that no video links to any more and
+// videos_fts rows left behind by deleted videos. Actors with a profile are
+// kept, and so are actors and tags with aliases, so that later imports
+// using the aliases still resolve to them.
+func (r *VideoRepository) Prune() (*model.PruneResult, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var actors []model.Actor
-	for rows.Next() {
-		var a model.Actor
-		if err := rows.Scan(&a.ID, &a.Name); err != nil {
+	var result model.PruneResult
+	for _, step := range []struct {
+		query string
+		count *int
+	}{
+		{`DELETE FROM actors WHERE id NOT IN (SELECT actor_id FROM video_actors)
+			AND id NOT IN (SELECT actor_id FROM actor_aliases)
+			AND kana = '' AND romaji = '' AND image_path = '' AND notes = ''`, &result.Actors},
+		{`DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM video_tags)
+			AND id NOT IN (SELECT tag_id FROM tag_aliases)`, &result.Tags},
+		{"DELETE FROM videos_fts WHERE video_id NOT IN (SELECT id FROM videos)", &result.FTS},
+	} {
+		res, err := tx.Exec(step.query)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
 			return nil, err
 		}
-		actors = append(actors, a)
+		*step.count = int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListTags returns the tags selected by params with their video counts,
+// and the number of tags selected before Limit and Offset.
+func (r *VideoRepository) ListTags(params model.NameQueryParams) (*model.TagList, error) {
+	list := &model.TagList{Tags: []model.TagSummary{}}
+	total, err := r.listNamed(tagRelation, params, func(id int, name string, count int) {
+		list.Tags = append(list.Tags, model.TagSummary{Tag: model.Tag{ID: id, Name: name}, VideoCount: count})
+	})
+	list.Total = total
+	return list, err
+}
+
+// ListActors is ListTags for actors. Query also matches their readings and
+// aliases.
+func (r *VideoRepository) ListActors(params model.NameQueryParams) (*model.ActorList, error) {
+	list := &model.ActorList{Actors: []model.ActorSummary{}}
+	total, err := r.listNamed(actorRelation, params, func(id int, name string, count int) {
+		list.Actors = append(list.Actors, model.ActorSummary{Actor: model.Actor{ID: id, Name: name}, VideoCount: count})
+	})
+	list.Total = total
+	return list, err
+}
+
+func (r *VideoRepository) listNamed(rel relation, params model.NameQueryParams, add func(id int, name string, count int)) (int, error) {
+	n := rel.names
+	where := []string{"1=1"}
+	args := []interface{}{}
+	order := []string{}
+	if q := textnorm.Normalize(strings.TrimSpace(params.Query)); q != "" {
+		args = append(args, "%"+database.EscapeLike(q)+"%", database.EscapeLike(q)+"%")
+		like := "normalize_text(%s) LIKE $1 ESCAPE '\\'"
+		conds := []string{fmt.Sprintf(like, "n.name")}
+		for _, column := range rel.searchColumns {
+			conds = append(conds, fmt.Sprintf(like, "n."+column))
+		}
+		conds = append(conds, fmt.Sprintf("n.id IN (SELECT %s FROM %s WHERE %s)", n.AliasKey, n.AliasTable, fmt.Sprintf(like, "name")))
+		where = append(where, "("+strings.Join(conds, " OR ")+")")
+		order = append(order, "normalize_text(n.name) LIKE $2 ESCAPE '\\' DESC")
+	}
+	if params.Sort == "count" {
+		order = append(order, "video_count DESC")
+	}
+	order = append(order, "n.name", "n.id")
+
+	query := fmt.Sprintf(`SELECT n.id, n.name, COUNT(v.id) AS video_count
+		FROM %s n LEFT JOIN %s j ON j.%s = n.id LEFT JOIN videos v ON v.id = j.video_id AND v.deleted_at IS NULL
+		WHERE %s GROUP BY n.id`,
+		n.Table, rel.joinTable, rel.joinKey, strings.Join(where, " AND "))
+	if params.HideEmpty {
+		query += " HAVING video_count > 0"
+	}
+
+	var total int
+	if err := r.q.QueryRow("SELECT COUNT(*) FROM ("+query+")", args...).Scan(&total); err != nil {
+		return 0, err
+	}
+
+	query += " ORDER BY " + strings.Join(order, ", ")
+	if params.Limit > 0 || params.Offset > 0 {
+		limit := params.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		args = append(args, limit, params.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, count int
+		var name string
+		if err := rows.Scan(&id, &name, &count); err != nil {
+			return 0, err
+		}
+		add(id, name, count)
 	}
-	return actors, rows.Err()
+	return total, rows.Err()
 }
 
-func (r *VideoRepository) loadRelations(v *model.Video) error {
+// loadRelations fills in actors, tags, formats and rating for videos with
+// one query per relation. The IDs are bound as a single JSON array so the
+// page size is not limited by SQLite's host parameter limit.
+func (r *VideoRepository) loadRelations(videos []model.Video) error {
+	if len(videos) == 0 {
+		return nil
+	}
+	byID := make(map[string]*model.Video, len(videos))
+	ids := make([]string, len(videos))
+	for i := range videos {
+		v := &videos[i]
+		v.Actors = []model.Actor{}
+		v.Tags = []model.Tag{}
+		v.Formats = []model.VideoFormat{}
+		byID[v.ID] = v
+		ids[i] = v.ID
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
 	// Actors
-	rows, err := r.db.Query(`SELECT a.id, a.name FROM actors a
-		JOIN video_actors va ON va.actor_id = a.id WHERE va.video_id = $1 ORDER BY a.name`, v.ID)
+	rows, err := r.q.Query(`SELECT va.video_id, a.id, a.name FROM actors a
+		JOIN video_actors va ON va.actor_id = a.id WHERE va.video_id IN (SELECT value FROM json_each($1)) ORDER BY a.name`, string(idsJSON))
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
-	v.Actors = []model.Actor{}
 	for rows.Next() {
+		var videoID string
 		var a model.Actor
-		if err := rows.Scan(&a.ID, &a.Name); err != nil {
+		if err := rows.Scan(&videoID, &a.ID, &a.Name); err != nil {
 			return err
 		}
+		v := byID[videoID]
 		v.Actors = append(v.Actors, a)
 	}
 	if err := rows.Err(); err != nil {
@@ -206,18 +735,19 @@ func (r *VideoRepository) loadRelations(v *model.Video) error {
 	}
 
 	// Tags
-	tagRows, err := r.db.Query(`SELECT t.id, t.name FROM tags t
-		JOIN video_tags vt ON vt.tag_id = t.id WHERE vt.video_id = $1 ORDER BY t.name`, v.ID)
+	tagRows, err := r.q.Query(`SELECT vt.video_id, t.id, t.name FROM tags t
+		JOIN video_tags vt ON vt.tag_id = t.id WHERE vt.video_id IN (SELECT value FROM json_each($1)) ORDER BY t.name`, string(idsJSON))
 	if err != nil {
 		return err
 	}
 	defer tagRows.Close()
-	v.Tags = []model.Tag{}
 	for tagRows.Next() {
+		var videoID string
 		var t model.Tag
-		if err := tagRows.Scan(&t.ID, &t.Name); err != nil {
+		if err := tagRows.Scan(&videoID, &t.ID, &t.Name); err != nil {
 			return err
 		}
+		v := byID[videoID]
 		v.Tags = append(v.Tags, t)
 	}
 	if err := tagRows.Err(); err != nil {
@@ -225,17 +755,19 @@ func (r *VideoRepository) loadRelations(v *model.Video) error {
 	}
 
 	// Formats
-	fmtRows, err := r.db.Query(`SELECT id, name, file_path FROM video_formats WHERE video_id = $1 ORDER BY name`, v.ID)
+	fmtRows, err := r.q.Query(`SELECT video_id, id, name, file_path FROM video_formats
+		WHERE video_id IN (SELECT value FROM json_each($1)) ORDER BY name`, string(idsJSON))
 	if err != nil {
 		return err
 	}
 	defer fmtRows.Close()
-	v.Formats = []model.VideoFormat{}
 	for fmtRows.Next() {
+		var videoID string
 		var f model.VideoFormat
-		if err := fmtRows.Scan(&f.ID, &f.Name, &f.FilePath); err != nil {
+		if err := fmtRows.Scan(&videoID, &f.ID, &f.Name, &f.FilePath); err != nil {
 			return err
 		}
+		v := byID[videoID]
 		v.Formats = append(v.Formats, f)
 	}
 	if err := fmtRows.Err(); err != nil {
@@ -243,11 +775,18 @@ func (r *VideoRepository) loadRelations(v *model.Video) error {
 	}
 
 	// Rating
-	var rating sql.NullInt64
-	r.db.QueryRow("SELECT rating FROM ratings WHERE video_id = $1", v.ID).Scan(&rating)
-	if rating.Valid {
-		v.Rating = int(rating.Int64)
+	ratingRows, err := r.q.Query(`SELECT video_id, rating FROM ratings WHERE video_id IN (SELECT value FROM json_each($1))`, string(idsJSON))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	defer ratingRows.Close()
+	for ratingRows.Next() {
+		var videoID string
+		var rating int
+		if err := ratingRows.Scan(&videoID, &rating); err != nil {
+			return err
+		}
+		byID[videoID].Rating = rating
+	}
+	return ratingRows.Err()
 }
@@ -1,13 +1,19 @@
 package repository
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/importer"
 	"github.com/iwaco/movies/internal/model"
 )
 
-func setupTestDB(t *testing.T) *database.DB {
+func setupTestDB(t testing.TB) *database.DB {
 	t.Helper()
 	db, err := database.New(":memory:")
 	if err != nil {
@@ -29,16 +35,15 @@ func seedTestData(t *testing.T, db *database.DB) {
 		`INSERT INTO video_tags (video_id, tag_id) VALUES ('vid1', 1), ('vid1', 2), ('vid2', 2), ('vid2', 3), ('vid3', 3)`,
 		`INSERT INTO video_formats (video_id, name, file_path) VALUES ('vid1', '720p', '/720p_1.mp4'), ('vid1', '1080p', '/1080p_1.mp4'), ('vid2', '480p', '/480p_2.mp4')`,
 		`INSERT INTO ratings (video_id, rating) VALUES ('vid1', 4)`,
-		`INSERT INTO videos_fts (video_id, title, actors, tags) VALUES
-			('vid1', 'First Video', 'Actor A,Actor B', 'tag1,tag2'),
-			('vid2', 'Second Video', 'Actor B,Actor C', 'tag2,tag3'),
-			('vid3', 'Third Video', 'Actor A', 'tag3')`,
 	}
 	for _, q := range queries {
 		if _, err := db.Exec(q); err != nil {
 			t.Fatalf("failed to seed data: %v\nquery: %s", err, q)
 		}
 	}
+	if err := database.RebuildFTS(db); err != nil {
+		t.Fatalf("failed to index: %v", err)
+	}
 }
 
 func TestVideoRepositoryList(t *testing.T) {
@@ -280,6 +285,53 @@ func TestVideoRepositoryFilterByTagsAndActors(t *testing.T) {
 	}
 }
 
+func TestVideoRepositoryFilterBooleanTagsAndActors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+
+	// vid1: Actor A, Actor B / tag1, tag2
+	// vid2: Actor B, Actor C / tag2, tag3
+	// vid3: Actor A / tag3
+	tests := []struct {
+		name        string
+		params      model.VideoQueryParams
+		expectedIDs []string
+	}{
+		{"タグのいずれか", model.VideoQueryParams{Tags: []string{"tag1", "tag3"}, TagMode: "any"}, []string{"vid3", "vid2", "vid1"}},
+		{"タグのすべて", model.VideoQueryParams{Tags: []string{"tag1", "tag3"}, TagMode: "all"}, nil},
+		{"タグの除外", model.VideoQueryParams{ExcludeTags: []string{"tag2"}}, []string{"vid3"}},
+		{"出演者のいずれか", model.VideoQueryParams{Actors: []string{"Actor A", "Actor C"}, ActorMode: "any"}, []string{"vid3", "vid2", "vid1"}},
+		{"出演者の除外", model.VideoQueryParams{ExcludeActors: []string{"Actor A", "Actor C"}}, nil},
+		{
+			"出演者AまたはC、tag2あり、tag1なし",
+			model.VideoQueryParams{Actors: []string{"Actor A", "Actor C"}, ActorMode: "any", Tags: []string{"tag2"}, ExcludeTags: []string{"tag1"}},
+			[]string{"vid2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			params.Page, params.PerPage, params.Sort = 1, 20, "date_desc"
+			result, err := repo.List(params)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if result.Total != len(tt.expectedIDs) {
+				t.Errorf("expected %d results, got %d", len(tt.expectedIDs), result.Total)
+			}
+			for i, expectedID := range tt.expectedIDs {
+				if i < len(result.Data) && result.Data[i].ID != expectedID {
+					t.Errorf("expected result[%d] ID=%s, got %s", i, expectedID, result.Data[i].ID)
+				}
+			}
+		})
+	}
+}
+
 func TestVideoRepositoryFilterByDateRange(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -419,6 +471,9 @@ func TestVideoRepositorySearchJapaneseTitle(t *testing.T) {
 			t.Fatalf("failed to seed Japanese data: %v", err)
 		}
 	}
+	if err := database.RebuildFTS(db); err != nil {
+		t.Fatalf("failed to rebuild fts: %v", err)
+	}
 
 	repo := NewVideoRepository(db)
 
@@ -434,6 +489,57 @@ func TestVideoRepositorySearchJapaneseTitle(t *testing.T) {
 		{"ヒットしないクエリ", "存在しない", nil},
 		{"ワイルドカード%はリテラル扱い", "%", nil},
 		{"ワイルドカード_はリテラル扱い", "_", nil},
+		{"ひらがなとカタカナを区別しない", "ぼよよん", []string{"vid4"}},
+		{"半角カナでヒット", "ﾎﾞﾖﾖﾝ", []string{"vid4"}},
+		{"全角英字でヒット", "ＯＬ", []string{"vid5"}},
+		{"2文字の日本語", "休日", []string{"vid5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := repo.List(model.VideoQueryParams{
+				Page: 1, PerPage: 20, Sort: "date_desc", Query: tt.query,
+			})
+			if err != nil {
+				t.Fatalf("failed to search '%s': %v", tt.query, err)
+			}
+			if result.Total != len(tt.expectedIDs) {
+				t.Errorf("query '%s': expected %d results, got %d", tt.query, len(tt.expectedIDs), result.Total)
+			}
+			for i, expectedID := range tt.expectedIDs {
+				if i < len(result.Data) && result.Data[i].ID != expectedID {
+					t.Errorf("query '%s': expected result[%d] ID=%s, got %s", tt.query, i, expectedID, result.Data[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestVideoRepositorySearchSyntax(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+
+	tests := []struct {
+		name        string
+		query       string
+		expectedIDs []string
+	}{
+		{"前方一致", "Fir*", []string{"vid1"}},
+		{"語の途中は前方一致しない", "irst*", nil},
+		{"短い語の前方一致", "actor:c*", []string{"vid2"}},
+		{"フレーズ検索", `"Second Video"`, []string{"vid2"}},
+		{"語順の違うフレーズはヒットしない", `"Video Second"`, nil},
+		{"タイトル列指定", "title:third", []string{"vid3"}},
+		{"出演者列指定", `actor:"Actor C"`, []string{"vid2"}},
+		{"タグ列指定", "tag:tag1", []string{"vid1"}},
+		{"列指定で他の列はヒットしない", "title:tag1", nil},
+		{"動画IDはヒットしない", "vid1", nil},
+		{"複数語はAND", "Video tag3", []string{"vid3", "vid2"}},
+		{"短い語もAND", "B Video", []string{"vid2", "vid1"}},
+		{"ANDは語順によらない", "Video B", []string{"vid2", "vid1"}},
 	}
 
 	for _, tt := range tests {
@@ -456,6 +562,43 @@ func TestVideoRepositorySearchJapaneseTitle(t *testing.T) {
 	}
 }
 
+func TestVideoRepositorySortRelevance(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	// vid2 matches "Second" in its title; vid3 only in a tag.
+	queries := []string{
+		`UPDATE videos_fts SET tags = 'tag3,second' WHERE video_id = 'vid3'`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("failed to seed: %v", err)
+		}
+	}
+
+	repo := NewVideoRepository(db)
+	result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Sort: "relevance", Query: "second"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if result.Total != 2 {
+		t.Fatalf("expected 2 results, got %d", result.Total)
+	}
+	if result.Data[0].ID != "vid2" {
+		t.Errorf("expected title match vid2 first, got %s", result.Data[0].ID)
+	}
+
+	// Without a query, relevance falls back to date_desc
+	result, err = repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Sort: "relevance"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if result.Data[0].ID != "vid3" {
+		t.Errorf("expected vid3 first, got %s", result.Data[0].ID)
+	}
+}
+
 func TestVideoRepositorySortDateAsc(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -471,6 +614,126 @@ func TestVideoRepositorySortDateAsc(t *testing.T) {
 	}
 }
 
+func TestVideoRepositorySortRating(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+	if _, err := db.Exec(`INSERT INTO ratings (video_id, rating) VALUES ('vid2', 2)`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	repo := NewVideoRepository(db)
+
+	// vid1=4, vid2=2, vid3 unrated and always last
+	tests := []struct {
+		sort        string
+		expectedIDs []string
+	}{
+		{"rating_desc", []string{"vid1", "vid2", "vid3"}},
+		{"rating_asc", []string{"vid2", "vid1", "vid3"}},
+	}
+	for _, tt := range tests {
+		result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Sort: tt.sort})
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		for i, expectedID := range tt.expectedIDs {
+			if result.Data[i].ID != expectedID {
+				t.Errorf("%s: expected result[%d] ID=%s, got %s", tt.sort, i, expectedID, result.Data[i].ID)
+			}
+		}
+	}
+}
+
+func TestVideoRepositorySortCreatedAndUpdated(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	queries := []string{
+		`UPDATE videos SET created_at = '2024-01-01 00:00:00', updated_at = '2024-06-01 00:00:00' WHERE id = 'vid1'`,
+		`UPDATE videos SET created_at = '2024-03-01 00:00:00', updated_at = '2024-03-01 00:00:00' WHERE id = 'vid2'`,
+		`UPDATE videos SET created_at = '2024-02-01 00:00:00', updated_at = '2024-02-01 00:00:00' WHERE id = 'vid3'`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("failed to seed: %v", err)
+		}
+	}
+
+	repo := NewVideoRepository(db)
+	tests := []struct {
+		sort        string
+		expectedIDs []string
+	}{
+		{"created_desc", []string{"vid2", "vid3", "vid1"}},
+		{"updated_desc", []string{"vid1", "vid2", "vid3"}},
+	}
+	for _, tt := range tests {
+		result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Sort: tt.sort})
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		for i, expectedID := range tt.expectedIDs {
+			if result.Data[i].ID != expectedID {
+				t.Errorf("%s: expected result[%d] ID=%s, got %s", tt.sort, i, expectedID, result.Data[i].ID)
+			}
+		}
+	}
+
+	// Updates within the same second are ordered by their milliseconds,
+	// also across cursor pages
+	if _, err := db.Exec(`UPDATE videos SET updated_at = CASE id
+		WHEN 'vid1' THEN '2024-06-01 00:00:00.100' WHEN 'vid2' THEN '2024-06-01 00:00:00.900' ELSE '2024-06-01 00:00:00' END`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+	var ids []string
+	params := model.VideoQueryParams{Page: 1, PerPage: 1, Sort: "updated_desc"}
+	for range 3 {
+		result, err := repo.List(params)
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		for _, v := range result.Data {
+			ids = append(ids, v.ID)
+		}
+		params.Cursor = result.NextCursor
+	}
+	if fmt.Sprint(ids) != "[vid2 vid1 vid3]" {
+		t.Errorf("expected updated_desc by milliseconds, got %v", ids)
+	}
+}
+
+func TestVideoRepositorySortRandom(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedManyVideos(t, db, 30)
+
+	repo := NewVideoRepository(db)
+	order := func(seed int64) []string {
+		result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 30, Sort: "random", Seed: seed})
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if result.Seed != seed {
+			t.Errorf("expected seed %d in result, got %d", seed, result.Seed)
+		}
+		ids := make([]string, len(result.Data))
+		for i, v := range result.Data {
+			ids[i] = v.ID
+		}
+		return ids
+	}
+
+	first, again, other := order(1), order(1), order(2)
+	if fmt.Sprint(first) != fmt.Sprint(again) {
+		t.Errorf("expected the same order for the same seed:\n%v\n%v", first, again)
+	}
+	if fmt.Sprint(first) == fmt.Sprint(other) {
+		t.Errorf("expected a different order for a different seed: %v", first)
+	}
+}
+
 func TestVideoRepositoryGetByID(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -504,18 +767,393 @@ func TestVideoRepositoryGetByIDNotFound(t *testing.T) {
 	}
 }
 
+func TestVideoRepositoryUpdate(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	if _, err := db.Exec("UPDATE videos SET content_hash = 'imported'"); err != nil {
+		t.Fatalf("failed to seed hash: %v", err)
+	}
+	repo := NewVideoRepository(db)
+	title := "最初の動画"
+	video, err := repo.Update("vid1", model.VideoUpdate{
+		Title:        &title,
+		AddActors:    []string{"Actor D"},
+		RemoveActors: []string{"Actor A"},
+		Tags:         &[]string{"tag3", "新タグ"},
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	if video.Title != "最初の動画" {
+		t.Errorf("expected updated title, got %s", video.Title)
+	}
+	if video.URL != "https://example.com/1" || video.Date != "2024-01-15" {
+		t.Errorf("expected other fields to be kept, got url=%s date=%s", video.URL, video.Date)
+	}
+	var actors, tags []string
+	for _, a := range video.Actors {
+		actors = append(actors, a.Name)
+	}
+	for _, tg := range video.Tags {
+		tags = append(tags, tg.Name)
+	}
+	if fmt.Sprint(actors) != "[Actor B Actor D]" {
+		t.Errorf("expected actors [Actor B Actor D], got %v", actors)
+	}
+	if fmt.Sprint(tags) != "[tag3 新タグ]" {
+		t.Errorf("expected tags [tag3 新タグ], got %v", tags)
+	}
+	// The next import compares its record with the edited video
+	var hash string
+	db.QueryRow("SELECT content_hash FROM videos WHERE id = 'vid1'").Scan(&hash)
+	if hash != "" {
+		t.Errorf("expected the content hash to be cleared, got %q", hash)
+	}
+
+	// videos_fts follows the update
+	for query, expected := range map[string]int{"最初の動画": 1, "First": 0, "actor:\"Actor D\"": 1, "tag:新タグ": 1} {
+		result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20, Query: query})
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+		if result.Total != expected {
+			t.Errorf("query '%s': expected %d results, got %d", query, expected, result.Total)
+		}
+	}
+}
+
+func TestVideoRepositoryUpdateConflict(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	before, err := repo.GetByID("vid1")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+
+	title := "Edited"
+	after, err := repo.Update("vid1", model.VideoUpdate{Title: &title, UpdatedAt: &before.UpdatedAt})
+	if err != nil {
+		t.Fatalf("expected update based on the current version to succeed: %v", err)
+	}
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("expected updated_at to advance, got %v -> %v", before.UpdatedAt, after.UpdatedAt)
+	}
+
+	// A second update based on the old version is rejected
+	title = "Stale"
+	if _, err := repo.Update("vid1", model.VideoUpdate{Title: &title, UpdatedAt: &before.UpdatedAt}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	if _, err := repo.Update("nonexistent", model.VideoUpdate{Title: &title}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestVideoRepositoryDelete(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	if err := repo.Delete("vid1"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := repo.GetByID("vid1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows after delete, got %v", err)
+	}
+	if err := repo.Delete("vid1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted video, got %v", err)
+	}
+
+	for _, table := range []string{"video_actors", "video_tags", "video_formats", "ratings", "videos_fts"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE video_id = 'vid1'").Scan(&count); err != nil {
+			t.Fatalf("failed to count %s: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("expected no %s rows for vid1, got %d", table, count)
+		}
+	}
+}
+
+func TestVideoRepositoryHidesSoftDeleted(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	if _, err := db.Exec("UPDATE videos SET deleted_at = CURRENT_TIMESTAMP WHERE id = 'vid2'"); err != nil {
+		t.Fatalf("failed to soft-delete: %v", err)
+	}
+
+	repo := NewVideoRepository(db)
+	result, err := repo.List(model.VideoQueryParams{Query: "Video"})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if result.Total != 2 {
+		t.Errorf("expected 2 videos, got %d", result.Total)
+	}
+	if _, err := repo.GetByID("vid2"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a soft-deleted video, got %v", err)
+	}
+	tags, err := repo.ListTags(model.NameQueryParams{Sort: "count"})
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	counts := map[string]int{}
+	for _, tag := range tags.Tags {
+		counts[tag.Name] = tag.VideoCount
+	}
+	if counts["tag2"] != 1 || counts["tag3"] != 1 {
+		t.Errorf("expected soft-deleted videos not to be counted, got %v", counts)
+	}
+}
+
+func TestVideoRepositoryDeleteManyAndMatching(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	n, err := repo.DeleteMany([]string{"vid1", "missing"})
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 deleted, got %d", n)
+	}
+
+	// A search filter still sees the videos_fts rows it deletes
+	n, err = repo.DeleteMatching(model.VideoQueryParams{Query: "Second"})
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 deleted, got %d", n)
+	}
+
+	result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 20})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if result.Total != 1 || result.Data[0].ID != "vid3" {
+		t.Errorf("expected only vid3 to remain, got %+v", result.Data)
+	}
+}
+
+func TestVideoRepositorySnapshot(t *testing.T) {
+	// A file database, as a snapshot and a write need two connections
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	err = repo.Snapshot(func(snap *VideoRepository) error {
+		before, err := snap.Facets(model.VideoQueryParams{}, []string{"format"})
+		if err != nil {
+			return err
+		}
+		// A write while the snapshot is open neither waits for it nor
+		// shows up in it
+		if _, err := db.Exec("INSERT INTO video_formats (video_id, name, file_path) VALUES ('vid3', '4k', '/4k_3.mp4')"); err != nil {
+			return err
+		}
+		var formats int
+		err = snap.Each(model.VideoQueryParams{}, 2, func(v model.Video) error {
+			formats += len(v.Formats)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(before.Facets["format"]) != 3 || formats != 3 {
+			t.Errorf("expected the snapshot to see 3 formats, got %d names and %d formats", len(before.Facets["format"]), formats)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read the snapshot: %v", err)
+	}
+
+	after, err := repo.Facets(model.VideoQueryParams{}, []string{"format"})
+	if err != nil {
+		t.Fatalf("failed to count formats: %v", err)
+	}
+	if len(after.Facets["format"]) != 4 {
+		t.Errorf("expected the write to be visible after the snapshot, got %+v", after.Facets["format"])
+	}
+}
+
+func TestVideoRepositoryPrune(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	if err := repo.Delete("vid2"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO videos_fts (video_id, title, actors, tags) VALUES ('gone', 'Gone', '', '')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	result, err := repo.Prune()
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	// Actor C only appeared in vid2; every tag is still used
+	if *result != (model.PruneResult{Actors: 1, Tags: 0, FTS: 1}) {
+		t.Errorf("unexpected prune result: %+v", *result)
+	}
+
+	actors, err := repo.ListActors(model.NameQueryParams{})
+	if err != nil {
+		t.Fatalf("failed to list actors: %v", err)
+	}
+	if len(actors.Actors) != 2 {
+		t.Errorf("expected 2 actors after prune, got %d", len(actors.Actors))
+	}
+
+	result, err = repo.Prune()
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if *result != (model.PruneResult{}) {
+		t.Errorf("expected nothing left to prune, got %+v", *result)
+	}
+}
+
+func TestVideoRepositoryPruneKeepsAliases(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	if err := NewTagRepository(db).AddAlias(1, "Tag One"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	if err := NewActorRepository(db).AddAlias(3, "C-san"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+
+	// tag1 and Actor C are left without videos, as are tag2 and Actor B,
+	// which have no aliases
+	repo := NewVideoRepository(db)
+	if _, err := repo.DeleteMany([]string{"vid1", "vid2"}); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	result, err := repo.Prune()
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if *result != (model.PruneResult{Actors: 1, Tags: 1, FTS: 0}) {
+		t.Errorf("unexpected prune result: %+v", *result)
+	}
+
+	// Re-importing with the aliases links the kept rows
+	input := `[{"id": "vid4", "title": "Fourth Video", "actors": ["C-san"], "tags": ["Tag One"]}]`
+	if _, err := importer.New(db).Import([]byte(input)); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	video, err := repo.GetByID("vid4")
+	if err != nil {
+		t.Fatalf("failed to get vid4: %v", err)
+	}
+	if len(video.Tags) != 1 || video.Tags[0].ID != 1 || len(video.Actors) != 1 || video.Actors[0].ID != 3 {
+		t.Errorf("expected vid4 to link tag1 and Actor C, got tags %+v and actors %+v", video.Tags, video.Actors)
+	}
+}
+
 func TestVideoRepositoryListTags(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 	seedTestData(t, db)
 
 	repo := NewVideoRepository(db)
-	tags, err := repo.ListTags()
+	tags, err := repo.ListTags(model.NameQueryParams{})
 	if err != nil {
 		t.Fatalf("failed to list tags: %v", err)
 	}
-	if len(tags) != 3 {
-		t.Errorf("expected 3 tags, got %d", len(tags))
+	if len(tags.Tags) != 3 {
+		t.Errorf("expected 3 tags, got %d", len(tags.Tags))
+	}
+}
+
+func TestVideoRepositoryListTagsParams(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+	if _, err := db.Exec(`INSERT INTO tags (name) VALUES ('xtag9'), ('TAG9x')`); err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+
+	repo := NewVideoRepository(db)
+	tests := []struct {
+		name   string
+		params model.NameQueryParams
+		expect string
+		total  int
+	}{
+		{"名前順", model.NameQueryParams{}, "[TAG9x:0 tag1:1 tag2:2 tag3:2 xtag9:0]", 5},
+		{"件数順", model.NameQueryParams{Sort: "count"}, "[tag2:2 tag3:2 tag1:1 TAG9x:0 xtag9:0]", 5},
+		{"空を除外", model.NameQueryParams{HideEmpty: true}, "[tag1:1 tag2:2 tag3:2]", 3},
+		{"前方一致を優先", model.NameQueryParams{Query: "ｔａｇ9"}, "[TAG9x:0 xtag9:0]", 2},
+		{"部分一致", model.NameQueryParams{Query: "AG2"}, "[tag2:2]", 1},
+		{"ページング", model.NameQueryParams{Sort: "count", Limit: 2, Offset: 1}, "[tag3:2 tag1:1]", 5},
+		{"オフセットのみ", model.NameQueryParams{Offset: 4}, "[xtag9:0]", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := repo.ListTags(tt.params)
+			if err != nil {
+				t.Fatalf("failed to list tags: %v", err)
+			}
+			var got []string
+			for _, tag := range list.Tags {
+				got = append(got, fmt.Sprintf("%s:%d", tag.Name, tag.VideoCount))
+			}
+			if fmt.Sprint(got) != tt.expect {
+				t.Errorf("expected %s, got %v", tt.expect, got)
+			}
+			if list.Total != tt.total {
+				t.Errorf("expected total %d, got %d", tt.total, list.Total)
+			}
+		})
+	}
+}
+
+func TestVideoRepositoryListActorsMatchesReadingsAndAliases(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	actors := NewActorRepository(db)
+	kana := "あくたーしー"
+	if _, err := actors.Update(3, model.ActorUpdate{Kana: &kana}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := actors.AddAlias(2, "Bee"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+
+	repo := NewVideoRepository(db)
+	for query, expect := range map[string]string{"アクター": "Actor C", "bee": "Actor B"} {
+		list, err := repo.ListActors(model.NameQueryParams{Query: query})
+		if err != nil {
+			t.Fatalf("failed to list actors: %v", err)
+		}
+		if len(list.Actors) != 1 || list.Actors[0].Name != expect {
+			t.Errorf("query '%s': expected %s, got %v", query, expect, list.Actors)
+		}
 	}
 }
 
@@ -570,11 +1208,306 @@ func TestVideoRepositoryListActors(t *testing.T) {
 	seedTestData(t, db)
 
 	repo := NewVideoRepository(db)
-	actors, err := repo.ListActors()
+	actors, err := repo.ListActors(model.NameQueryParams{})
 	if err != nil {
 		t.Fatalf("failed to list actors: %v", err)
 	}
-	if len(actors) != 3 {
-		t.Errorf("expected 3 actors, got %d", len(actors))
+	if len(actors.Actors) != 3 {
+		t.Errorf("expected 3 actors, got %d", len(actors.Actors))
+	}
+}
+
+func TestVideoRepositoryFacets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+
+	// Actor B appears in vid1 and vid2
+	result, err := repo.Facets(model.VideoQueryParams{Actors: []string{"Actor B"}}, nil)
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if result.Total != 2 {
+		t.Errorf("expected total 2, got %d", result.Total)
+	}
+
+	expected := map[string][]model.FacetCount{
+		"tag":    {{Value: "tag2", Count: 2}, {Value: "tag1", Count: 1}, {Value: "tag3", Count: 1}},
+		"actor":  {{Value: "Actor B", Count: 2}, {Value: "Actor A", Count: 1}, {Value: "Actor C", Count: 1}},
+		"year":   {{Value: "2024", Count: 2}},
+		"rating": {{Value: "4", Count: 1}},
+		"format": {{Value: "1080p", Count: 1}, {Value: "480p", Count: 1}, {Value: "720p", Count: 1}},
+	}
+	for name, want := range expected {
+		got := result.Facets[name]
+		if len(got) != len(want) {
+			t.Errorf("facet %s: expected %v, got %v", name, want, got)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("facet %s[%d]: expected %v, got %v", name, i, want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestVideoRepositoryFacetsSubset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	result, err := repo.Facets(model.VideoQueryParams{Query: "Third"}, []string{"tag"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if len(result.Facets) != 1 {
+		t.Errorf("expected only the tag facet, got %v", result.Facets)
+	}
+	tags := result.Facets["tag"]
+	if len(tags) != 1 || tags[0].Value != "tag3" || tags[0].Count != 1 {
+		t.Errorf("expected tag3=1, got %v", tags)
+	}
+
+	if _, err := repo.Facets(model.VideoQueryParams{}, []string{"unknown"}); err == nil {
+		t.Error("expected error for unknown facet")
+	}
+}
+
+// seedManyVideos inserts n videos, each with two actors, two tags, a format
+// and a rating.
+func seedManyVideos(t testing.TB, db *database.DB, n int) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+	for i := 0; i < 20; i++ {
+		tx.Exec("INSERT INTO actors (name) VALUES ($1)", fmt.Sprintf("Actor %02d", i))
+		tx.Exec("INSERT INTO tags (name) VALUES ($1)", fmt.Sprintf("tag%02d", i))
+	}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("vid%05d", i)
+		queries := []struct {
+			query string
+			args  []interface{}
+		}{
+			{"INSERT INTO videos (id, title, date) VALUES ($1, $2, $3)", []interface{}{id, "Video " + id, fmt.Sprintf("2024-01-%02d", i%28+1)}},
+			{"INSERT INTO video_actors (video_id, actor_id) VALUES ($1, $2), ($1, $3)", []interface{}{id, i%20 + 1, (i+1)%20 + 1}},
+			{"INSERT INTO video_tags (video_id, tag_id) VALUES ($1, $2), ($1, $3)", []interface{}{id, i%20 + 1, (i+7)%20 + 1}},
+			{"INSERT INTO video_formats (video_id, name, file_path) VALUES ($1, '720p', $2)", []interface{}{id, "/" + id + ".mp4"}},
+			{"INSERT INTO ratings (video_id, rating) VALUES ($1, $2)", []interface{}{id, i%5 + 1}},
+		}
+		for _, q := range queries {
+			if _, err := tx.Exec(q.query, q.args...); err != nil {
+				t.Fatalf("failed to seed: %v\nquery: %s", err, q.query)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+func TestVideoRepositoryListLoadsRelationsForLargePage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedManyVideos(t, db, 1500)
+
+	repo := NewVideoRepository(db)
+	result, err := repo.List(model.VideoQueryParams{Page: 1, PerPage: 1500, Sort: "title_asc"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if len(result.Data) != 1500 {
+		t.Fatalf("expected %d videos, got %d", 1500, len(result.Data))
+	}
+	for i, v := range result.Data {
+		if len(v.Actors) != 2 || len(v.Tags) != 2 || len(v.Formats) != 1 {
+			t.Fatalf("video %s: expected 2 actors, 2 tags, 1 format, got %d, %d, %d", v.ID, len(v.Actors), len(v.Tags), len(v.Formats))
+		}
+		if v.Rating != i%5+1 {
+			t.Fatalf("video %s: expected rating %d, got %d", v.ID, i%5+1, v.Rating)
+		}
+	}
+}
+
+func TestVideoRepositoryListCursor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedManyVideos(t, db, 57)
+	if err := database.RebuildFTS(db); err != nil {
+		t.Fatalf("failed to rebuild fts: %v", err)
+	}
+
+	repo := NewVideoRepository(db)
+	for _, sort := range model.VideoSorts {
+		t.Run(sort, func(t *testing.T) {
+			base := model.VideoQueryParams{PerPage: 100, Sort: sort, Query: "video", Seed: 42}
+			all, err := repo.List(base)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if all.NextCursor != "" {
+				t.Errorf("expected no next cursor when everything fits, got %q", all.NextCursor)
+			}
+
+			var ids []string
+			params := base
+			params.PerPage = 10
+			params.SkipTotal = true
+			for pages := 0; ; pages++ {
+				if pages > 10 {
+					t.Fatal("cursor pagination did not terminate")
+				}
+				result, err := repo.List(params)
+				if err != nil {
+					t.Fatalf("failed: %v", err)
+				}
+				for _, v := range result.Data {
+					ids = append(ids, v.ID)
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				params.Cursor = result.NextCursor
+			}
+
+			if len(ids) != len(all.Data) {
+				t.Fatalf("expected %d videos over all pages, got %d", len(all.Data), len(ids))
+			}
+			for i := range ids {
+				if ids[i] != all.Data[i].ID {
+					t.Fatalf("position %d: expected %s, got %s", i, all.Data[i].ID, ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVideoRepositoryListCursorStableAcrossInserts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	first, err := repo.List(model.VideoQueryParams{PerPage: 1, Sort: "date_desc"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if first.Data[0].ID != "vid3" || first.NextCursor == "" {
+		t.Fatalf("expected vid3 with a next cursor, got %s %q", first.Data[0].ID, first.NextCursor)
+	}
+
+	// A newer video inserted ahead of the cursor does not shift the next page
+	if _, err := db.Exec(`INSERT INTO videos (id, title, date) VALUES ('vid4', 'Fourth Video', '2024-12-01')`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	second, err := repo.List(model.VideoQueryParams{PerPage: 1, Sort: "date_desc", Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if second.Data[0].ID != "vid2" {
+		t.Errorf("expected vid2 after cursor, got %s", second.Data[0].ID)
+	}
+}
+
+func TestVideoRepositoryListInvalidCursor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	first, err := repo.List(model.VideoQueryParams{PerPage: 1, Sort: "date_desc"})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+
+	for _, params := range []model.VideoQueryParams{
+		{PerPage: 1, Sort: "date_desc", Cursor: "not-a-cursor"},
+		{PerPage: 1, Sort: "title_asc", Cursor: first.NextCursor},
+	} {
+		if _, err := repo.List(params); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q with sort %s: expected ErrInvalidCursor, got %v", params.Cursor, params.Sort, err)
+		}
+	}
+}
+
+func TestVideoRepositoryListSkipTotal(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	result, err := repo.List(model.VideoQueryParams{PerPage: 2, Sort: "date_desc", SkipTotal: true})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if len(result.Data) != 2 {
+		t.Errorf("expected 2 videos, got %d", len(result.Data))
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if _, ok := decoded["total"]; ok {
+		t.Errorf("expected total to be omitted, got %s", data)
+	}
+	if _, ok := decoded["next_cursor"]; !ok {
+		t.Errorf("expected next_cursor, got %s", data)
+	}
+}
+
+func TestVideoRepositoryEach(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	seedTestData(t, db)
+
+	repo := NewVideoRepository(db)
+	var ids []string
+	err := repo.Each(model.VideoQueryParams{Page: 3, PerPage: 20, Sort: "date_asc"}, 2, func(v model.Video) error {
+		ids = append(ids, v.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed: %v", err)
+	}
+	if fmt.Sprint(ids) != "[vid1 vid2 vid3]" {
+		t.Errorf("expected every video in order, got %v", ids)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = repo.Each(model.VideoQueryParams{Sort: "date_asc"}, 2, func(v model.Video) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected to stop at the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func BenchmarkVideoRepositoryList(b *testing.B) {
+	db := setupTestDB(b)
+	defer db.Close()
+	seedManyVideos(b, db, 2000)
+
+	repo := NewVideoRepository(db)
+	for _, perPage := range []int{20, 100, 500, 1000} {
+		b.Run(fmt.Sprintf("per_page=%d", perPage), func(b *testing.B) {
+			params := model.VideoQueryParams{Page: 1, PerPage: perPage, Sort: "date_desc"}
+			for b.Loop() {
+				if _, err := repo.List(params); err != nil {
+					b.Fatalf("failed: %v", err)
+				}
+			}
+		})
 	}
 }
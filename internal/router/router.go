@@ -15,14 +15,21 @@ import (
 	"github.com/iwaco/movies/internal/repository"
 )
 
-func New(db *sql.DB, cfg *config.Config) *chi.Mux {
+// New builds the router. jobs runs the background imports; the caller
+// shuts it down after the server.
+func New(db *sql.DB, cfg *config.Config, jobs *importer.Jobs) *chi.Mux {
 	videoRepo := repository.NewVideoRepository(db)
 	ratingRepo := repository.NewRatingRepository(db)
+	tagRepo := repository.NewTagRepository(db)
+	actorRepo := repository.NewActorRepository(db)
 	imp := importer.New(db)
 
 	vh := handler.NewVideoHandler(videoRepo, cfg.MediaRoot)
 	rh := handler.NewRatingHandler(ratingRepo)
-	ih := handler.NewImportHandler(imp)
+	th := handler.NewTagHandler(tagRepo)
+	ah := handler.NewActorHandler(actorRepo, cfg.MediaRoot)
+	ih := handler.NewImportHandler(imp, jobs, cfg.ImportBatchSize, cfg.SyncMaxRemovePercent)
+	eh := handler.NewExportHandler(videoRepo)
 	hh := handler.NewHealthHandler(db)
 
 	r := chi.NewRouter()
@@ -30,8 +37,9 @@ func New(db *sql.DB, cfg *config.Config) *chi.Mux {
 	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match"},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
@@ -41,13 +49,35 @@ func New(db *sql.DB, cfg *config.Config) *chi.Mux {
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Get("/videos", vh.List)
+		r.Delete("/videos", vh.DeleteMany)
+		r.Get("/videos/facets", vh.Facets)
 		r.Get("/videos/{id}", vh.GetByID)
+		r.Patch("/videos/{id}", vh.Update)
+		r.Delete("/videos/{id}", vh.Delete)
 		r.Get("/videos/{id}/pictures", vh.GetPictures)
+		r.Get("/videos/{id}/history", ih.VideoHistory)
 		r.Get("/tags", vh.ListTags)
+		r.Patch("/tags/{id}", th.Rename)
+		r.Post("/tags/{id}/merge", th.Merge)
+		r.Get("/tags/{id}/aliases", th.ListAliases)
+		r.Post("/tags/{id}/aliases", th.AddAlias)
+		r.Delete("/tags/{id}/aliases/{alias}", th.RemoveAlias)
 		r.Get("/actors", vh.ListActors)
+		r.Get("/actors/{id}", ah.Get)
+		r.Patch("/actors/{id}", ah.Update)
+		r.Post("/actors/{id}/merge", ah.Merge)
+		r.Post("/actors/{id}/aliases", ah.AddAlias)
+		r.Delete("/actors/{id}/aliases/{alias}", ah.RemoveAlias)
+		r.Post("/prune", vh.Prune)
 		r.Put("/ratings/{videoID}", rh.Set)
 		r.Delete("/ratings/{videoID}", rh.Remove)
 		r.Post("/import", ih.Import)
+		r.Post("/import/jobs", ih.StartJob)
+		r.Get("/import/jobs", ih.ListJobs)
+		r.Get("/import/jobs/{id}", ih.GetJob)
+		r.Post("/import/jobs/{id}/cancel", ih.CancelJob)
+		r.Get("/import/runs", ih.ListRuns)
+		r.Get("/export", eh.Export)
 	})
 
 	r.Handle("/media/*", handler.NewMediaHandler(cfg.MediaRoot))
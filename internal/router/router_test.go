@@ -7,6 +7,7 @@ import (
 
 	"github.com/iwaco/movies/internal/config"
 	"github.com/iwaco/movies/internal/database"
+	"github.com/iwaco/movies/internal/importer"
 )
 
 func TestNewRouter(t *testing.T) {
@@ -22,7 +23,7 @@ func TestNewRouter(t *testing.T) {
 		Port:      "8080",
 	}
 
-	r := New(db, cfg)
+	r := New(db, cfg, importer.NewJobs(importer.New(db), t.TempDir()))
 	if r == nil {
 		t.Fatal("expected non-nil router")
 	}
@@ -37,6 +38,7 @@ func TestNewRouter(t *testing.T) {
 		expect int
 	}{
 		{"GET", "/api/v1/videos", http.StatusOK},
+		{"GET", "/api/v1/videos/facets", http.StatusOK},
 		{"GET", "/api/v1/tags", http.StatusOK},
 		{"GET", "/api/v1/actors", http.StatusOK},
 		{"DELETE", "/api/v1/ratings/nonexistent", http.StatusNoContent},
@@ -0,0 +1,29 @@
+// Package textnorm normalizes titles, actor names and tags so that searches
+// ignore differences in character width, kana script and letter case.
+package textnorm
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var folder = cases.Fold()
+
+// Normalize applies NFKC (full-width ASCII becomes half-width, half-width
+// katakana becomes full-width), folds hiragana into katakana and folds case.
+// Both indexed text and search queries must go through Normalize.
+func Normalize(s string) string {
+	s = norm.NFKC.String(s)
+	s = strings.Map(foldKana, s)
+	return folder.String(s)
+}
+
+func foldKana(r rune) rune {
+	switch {
+	case r >= 'ぁ' && r <= 'ゖ', r == 'ゝ', r == 'ゞ':
+		return r + 0x60
+	}
+	return r
+}
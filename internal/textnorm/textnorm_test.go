@@ -0,0 +1,28 @@
+package textnorm
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"ASCIIはそのまま小文字化", "Hello World", "hello world"},
+		{"全角英数字は半角", "ＡＢＣ１２３", "abc123"},
+		{"半角カナは全角", "ﾃｽﾄ", "テスト"},
+		{"半角カナの濁点は合成", "ﾃﾞｰﾀ", "データ"},
+		{"ひらがなはカタカナ", "てすと", "テスト"},
+		{"踊り字", "すゞき", "スヾキ"},
+		{"漢字はそのまま", "秘密の休日", "秘密ノ休日"},
+		{"全角記号", "ｔａｇ：ｘ", "tag:x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.input); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}